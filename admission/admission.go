@@ -0,0 +1,172 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package admission turns probe results into budgets for admission control,
+// in the style of CockroachDB's disk bandwidth admission control: a
+// provisioned bandwidth for the store, doled out as byte tokens that refill at
+// a fixed rate.
+package admission
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/irfansharif/probe"
+)
+
+// Capacity is what a device was measured to be capable of. Zero values are
+// unknown (i.e. not probed).
+type Capacity struct {
+	ReadBandwidth, WriteBandwidth uint64 // bytes/s
+	ReadIOPS, WriteIOPS           uint64
+}
+
+// CapacityOf assembles a device's capacity from probe results, using the
+// latest complete result of each kind. All results must be for the same
+// device.
+func CapacityOf(results ...probe.Result) (Capacity, error) {
+	var c Capacity
+	for _, r := range results {
+		if r.Device.ID() != results[0].Device.ID() {
+			return Capacity{}, fmt.Errorf("results span devices: %s and %s", results[0].Device, r.Device)
+		}
+	}
+	for kind, r := range probe.LatestByKind(results...) {
+		switch kind {
+		case probe.ReadBandwidth:
+			c.ReadBandwidth = r.Value
+		case probe.WriteBandwidth:
+			c.WriteBandwidth = r.Value
+		case probe.ReadIOPS:
+			c.ReadIOPS = r.Value
+		case probe.WriteIOPS:
+			c.WriteIOPS = r.Value
+		}
+	}
+	return c, nil
+}
+
+// Config configures how capacity is turned into a budget.
+type Config struct {
+	// Margin is the fraction of measured capacity held back, in [0, 1), e.g.
+	// 0.2 to provision 80% of what was measured.
+	Margin float64
+	// ReadFraction is the expected fraction of bytes transferred that are
+	// reads, in [0, 1], used to combine read and write capacity into a single
+	// provisioned bandwidth.
+	ReadFraction float64
+	// IOSize is the typical I/O size in bytes. If set, bandwidth is further
+	// limited to what the measured IOPS sustain at that size.
+	IOSize uint64
+	// RefillInterval is how often byte tokens are refilled. Defaults to 1s.
+	RefillInterval time.Duration
+}
+
+// Budget is what's provisioned for a device.
+type Budget struct {
+	// ReadBandwidth and WriteBandwidth are what's provisioned for reads and
+	// writes alone, in bytes/s.
+	ReadBandwidth, WriteBandwidth uint64
+	// Bandwidth is what's provisioned for the expected mix of reads and
+	// writes, in bytes/s.
+	Bandwidth uint64
+	// RefillInterval is how often tokens are refilled.
+	RefillInterval time.Duration
+	// ReadTokens, WriteTokens and Tokens are the byte tokens refilled every
+	// interval, corresponding to the bandwidths above.
+	ReadTokens, WriteTokens, Tokens uint64
+}
+
+// Provision computes a budget from the given capacity. Both read and write
+// bandwidth need to be known.
+//
+// Reads and writes share the device: transferring a byte mix with a fraction
+// r of reads takes r/R + (1-r)/W seconds per byte, where R and W are the read
+// and write bandwidths. The combined bandwidth is the inverse, i.e. the
+// weighted harmonic mean of the two.
+func Provision(c Capacity, cfg Config) (Budget, error) {
+	if cfg.Margin < 0 || cfg.Margin >= 1 {
+		return Budget{}, fmt.Errorf("invalid margin: %v", cfg.Margin)
+	}
+	if cfg.ReadFraction < 0 || cfg.ReadFraction > 1 {
+		return Budget{}, fmt.Errorf("invalid read fraction: %v", cfg.ReadFraction)
+	}
+	if c.ReadBandwidth == 0 || c.WriteBandwidth == 0 {
+		return Budget{}, fmt.Errorf("read and write bandwidth needed, got %+v", c)
+	}
+	if cfg.RefillInterval == 0 {
+		cfg.RefillInterval = time.Second
+	}
+
+	read, write := float64(c.ReadBandwidth), float64(c.WriteBandwidth)
+	if cfg.IOSize != 0 {
+		if c.ReadIOPS != 0 {
+			read = min(read, float64(c.ReadIOPS*cfg.IOSize))
+		}
+		if c.WriteIOPS != 0 {
+			write = min(write, float64(c.WriteIOPS*cfg.IOSize))
+		}
+	}
+	read *= 1 - cfg.Margin
+	write *= 1 - cfg.Margin
+	combined := 1 / (cfg.ReadFraction/read + (1-cfg.ReadFraction)/write)
+
+	b := Budget{
+		ReadBandwidth:  uint64(read),
+		WriteBandwidth: uint64(write),
+		Bandwidth:      uint64(combined),
+		RefillInterval: cfg.RefillInterval,
+	}
+	perInterval := func(bw uint64) uint64 {
+		return uint64(float64(bw) * cfg.RefillInterval.Seconds())
+	}
+	b.ReadTokens = perInterval(b.ReadBandwidth)
+	b.WriteTokens = perInterval(b.WriteBandwidth)
+	b.Tokens = perInterval(b.Bandwidth)
+	return b, nil
+}
+
+// StoreSpec returns the provisioned rate in the form accepted as part of
+// CockroachDB's --store flag, e.g.
+// "provisioned-rate=disk-name=nvme0n1:bandwidth=250MiB/s".
+func (b Budget) StoreSpec(diskName string) string {
+	return fmt.Sprintf("provisioned-rate=disk-name=%s:bandwidth=%s/s", diskName, byteSize(b.Bandwidth))
+}
+
+// ClusterSetting returns the statement setting the provisioned bandwidth as a
+// CockroachDB cluster setting, applying to stores without a provisioned rate
+// of their own.
+func (b Budget) ClusterSetting() string {
+	return fmt.Sprintf("SET CLUSTER SETTING kvadmission.store.provisioned_bandwidth = '%s'", byteSize(b.Bandwidth))
+}
+
+// byteSize formats the given bandwidth in whole MiB, or KiB or bytes if
+// smaller (rounding down, to stay within budget).
+func byteSize(bw uint64) string {
+	switch {
+	case bw >= 1<<20:
+		return fmt.Sprintf("%dMiB", bw>>20)
+	case bw >= 1<<10:
+		return fmt.Sprintf("%dKiB", bw>>10)
+	default:
+		return fmt.Sprintf("%dB", bw)
+	}
+}
+
+func min(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package admission_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/admission"
+)
+
+func TestProvision(t *testing.T) {
+	device := probe.Device{Major: 259, Minor: 0, Name: "nvme0n1"}
+	start := time.Now()
+	capacity, err := admission.CapacityOf(
+		probe.Result{Kind: probe.ReadBandwidth, Value: 100 << 20, Device: device, Start: start.Add(-time.Hour)},
+		probe.Result{Kind: probe.ReadBandwidth, Value: 400 << 20, Device: device, Start: start},
+		probe.Result{Kind: probe.WriteBandwidth, Value: 200 << 20, Device: device, Start: start},
+		probe.Result{Kind: probe.WriteBandwidth, Value: 1 << 20, Device: device, Start: start.Add(time.Hour), Incomplete: true},
+		probe.Result{Kind: probe.WriteIOPS, Value: 10000, Device: device, Start: start},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (admission.Capacity{ReadBandwidth: 400 << 20, WriteBandwidth: 200 << 20, WriteIOPS: 10000}); capacity != exp {
+		t.Fatalf("capacity = %+v, expected %+v", capacity, exp)
+	}
+
+	budget, err := admission.Provision(capacity, admission.Config{Margin: 0.25, ReadFraction: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 300MiB/s of reads, 150MiB/s of writes; an even mix is 200MiB/s.
+	if budget.ReadBandwidth != 300<<20 || budget.WriteBandwidth != 150<<20 || budget.Bandwidth != 200<<20 {
+		t.Errorf("unexpected budget: %+v", budget)
+	}
+	if budget.Tokens != 200<<20 || budget.RefillInterval != time.Second {
+		t.Errorf("unexpected tokens: %+v", budget)
+	}
+	if exp := "provisioned-rate=disk-name=nvme0n1:bandwidth=200MiB/s"; budget.StoreSpec("nvme0n1") != exp {
+		t.Errorf("store spec = %s, expected %s", budget.StoreSpec("nvme0n1"), exp)
+	}
+	if exp := "SET CLUSTER SETTING kvadmission.store.provisioned_bandwidth = '200MiB'"; budget.ClusterSetting() != exp {
+		t.Errorf("cluster setting = %s, expected %s", budget.ClusterSetting(), exp)
+	}
+	// Budgets below 1MiB/s aren't rounded down to nothing.
+	small := admission.Budget{Bandwidth: 768 << 10}
+	if exp := "provisioned-rate=disk-name=sda:bandwidth=768KiB/s"; small.StoreSpec("sda") != exp {
+		t.Errorf("store spec = %s, expected %s", small.StoreSpec("sda"), exp)
+	}
+
+	// Small writes are limited by IOPS: 10000 x 4KiB = ~39MiB/s.
+	budget, err = admission.Provision(capacity, admission.Config{ReadFraction: 0, IOSize: 4 << 10, RefillInterval: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.WriteBandwidth != 10000*4<<10 || budget.Bandwidth != budget.WriteBandwidth || budget.WriteTokens != budget.WriteBandwidth/10 {
+		t.Errorf("unexpected budget: %+v", budget)
+	}
+
+	if _, err := admission.Provision(admission.Capacity{ReadBandwidth: 1}, admission.Config{}); err == nil {
+		t.Error("expected error without write bandwidth")
+	}
+	if _, err := admission.CapacityOf(
+		probe.Result{Device: device},
+		probe.Result{Device: probe.Device{Major: 8, Minor: 0, Name: "sda"}},
+	); err == nil {
+		t.Error("expected error for results spanning devices")
+	}
+}
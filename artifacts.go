@@ -0,0 +1,214 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+)
+
+// Environment fingerprints where a probe ran, as recorded in its artifact
+// bundle (see WithArtifacts).
+type Environment struct {
+	Hostname string `json:"hostname"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	// Kernel is the kernel version, e.g. "6.1.0-13-amd64".
+	Kernel string `json:"kernel"`
+	// FioPath and FioVersion identify the fio that ran the probe.
+	FioPath    string `json:"fio_path"`
+	FioVersion string `json:"fio_version"`
+	// Filesystem, MountPoint, MountSource and MountOptions describe the
+	// mount the probe directory is on.
+	Filesystem   string   `json:"filesystem"`
+	MountPoint   string   `json:"mount_point"`
+	MountSource  string   `json:"mount_source"`
+	MountOptions []string `json:"mount_options"`
+	// Device is the block device backing the probe directory.
+	Device Device `json:"device"`
+	// CgroupLimits are the I/O limits imposed on the device, if any.
+	CgroupLimits IOLimits `json:"cgroup_limits"`
+}
+
+// artifactBundle is what's written out by WithArtifacts.
+type artifactBundle struct {
+	job    *Job
+	args   []string
+	run    fioRun
+	runErr error
+	device Device
+	limits IOLimits
+}
+
+// artifactTiming is the timing recorded in artifact bundles.
+type artifactTiming struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Elapsed string    `json:"elapsed"`
+	// Error is why fio failed, if it did.
+	Error string `json:"error,omitempty"`
+}
+
+// write writes the bundle to the configured path.
+func (b artifactBundle) write(o *options) error {
+	ini, err := b.job.INI()
+	if err != nil {
+		return err
+	}
+	env := Environment{
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		FioPath:      o.fioPath(),
+		FioVersion:   b.run.Output.FioVersion,
+		Device:       b.device,
+		CgroupLimits: b.limits,
+	}
+	// All best-effort.
+	env.Hostname, _ = os.Hostname()
+	env.Kernel, _ = host.KernelVersion()
+	if mount, ok := mountOf(o.Directory); ok {
+		env.Filesystem = mount.Fstype
+		env.MountPoint = mount.Mountpoint
+		env.MountSource = mount.Device
+		env.MountOptions = mount.Opts
+	}
+	timing := artifactTiming{
+		Start:   b.run.Start,
+		End:     b.run.Start.Add(b.run.Elapsed),
+		Elapsed: b.run.Elapsed.String(),
+	}
+	if b.runErr != nil {
+		timing.Error = b.runErr.Error()
+	}
+	envJSON, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
+	}
+	timingJSON, err := json.MarshalIndent(timing, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	var quoted []string
+	for _, arg := range append([]string{o.fioPath()}, b.args...) {
+		quoted = append(quoted, shellQuote(arg))
+	}
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"job.ini", []byte(ini)},
+		{"command.txt", []byte(strings.Join(quoted, " ") + "\n")},
+		{"output.json", b.run.Stdout},
+		{"stderr.txt", b.run.Stderr},
+		{"environment.json", append(envJSON, '\n')},
+		{"timing.json", append(timingJSON, '\n')},
+	}
+
+	path := o.Artifacts
+	if !strings.HasSuffix(path, ".tar") && !strings.HasSuffix(path, ".tar.gz") && !strings.HasSuffix(path, ".tgz") {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := os.WriteFile(filepath.Join(path, f.name), f.data, 0644); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	var gz *gzip.Writer
+	if !strings.HasSuffix(path, ".tar") {
+		gz = gzip.NewWriter(out)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	// Files go under a directory named after the bundle, as is customary.
+	dir := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(filepath.Base(path), ".gz"), ".tar"), ".tgz")
+	for _, f := range files {
+		hdr := &tar.Header{
+			Name:    dir + "/" + f.name,
+			Mode:    0644,
+			Size:    int64(len(f.data)),
+			ModTime: b.run.Start,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			_ = out.Close()
+			return err
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			_ = out.Close()
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			_ = out.Close()
+			return err
+		}
+	}
+	return out.Close()
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes the given argument for POSIX shells, if needed.
+func shellQuote(arg string) string {
+	if shellSafe.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// validateArtifacts checks that the artifact bundle isn't written within the
+// probe directory.
+func validateArtifacts(dir, artifacts string) error {
+	if artifacts == "" {
+		return nil
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	absArtifacts, err := filepath.Abs(artifacts)
+	if err != nil {
+		return err
+	}
+	if containsPath(absDir, absArtifacts) {
+		return fmt.Errorf("artifacts path %s is within the probe directory %s, which is cleared", artifacts, dir)
+	}
+	return nil
+}
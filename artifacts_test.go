@@ -0,0 +1,136 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/fiotest"
+)
+
+func TestFakeArtifacts(t *testing.T) {
+	stdout := fiotest.Output(fiotest.Stats{ReadIOPS: 42})
+	runner := &fiotest.Runner{Stdout: stdout, Stderr: []byte("fio: a warning\n")}
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.ReadIOPS),
+		probe.WithExtraArgs([]string{"--percentile_list=50:99"}))
+
+	t.Run("directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "artifacts")
+		res, err := probe.Run(context.Background(), append(opts, probe.WithArtifacts(dir))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Artifacts != dir {
+			t.Errorf("artifacts = %q, expected %q", res.Artifacts, dir)
+		}
+		read := func(name string) string {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(data)
+		}
+		if ini := read("job.ini"); !strings.HasPrefix(ini, "[read_iops]\n") ||
+			!strings.Contains(ini, "\nrw=randread\n") || !strings.Contains(ini, "\ntime_based\n") ||
+			!strings.Contains(ini, "\npercentile_list=50:99\n") {
+			t.Errorf("unexpected job file:\n%s", ini)
+		}
+		if cmd := read("command.txt"); !strings.HasPrefix(cmd, "fio --name=read_iops ") ||
+			!strings.Contains(cmd, "--output-format=json") {
+			t.Errorf("unexpected command line: %s", cmd)
+		}
+		if read("output.json") != string(stdout) || read("stderr.txt") != "fio: a warning\n" {
+			t.Error("expected fio's output verbatim")
+		}
+		var env probe.Environment
+		if err := json.Unmarshal([]byte(read("environment.json")), &env); err != nil {
+			t.Fatal(err)
+		}
+		if env.FioVersion != "fio-3.30" || env.OS == "" || env.Device != res.Device {
+			t.Errorf("unexpected environment: %+v", env)
+		}
+		if timing := read("timing.json"); !strings.Contains(timing, `"elapsed"`) {
+			t.Errorf("unexpected timing: %s", timing)
+		}
+	})
+
+	t.Run("tarball", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+		failing := &fiotest.Runner{Stderr: []byte("fio: ioengine libaio not loaded\n"), Err: errors.New("exit status 1")}
+		_, err := probe.Run(context.Background(),
+			append(opts, probe.WithArtifacts(path), probe.WithRunner(failing))...)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gz)
+		contents := make(map[string]string)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contents[hdr.Name] = string(data)
+		}
+		var names []string
+		for name := range contents {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if exp := "bundle/command.txt bundle/environment.json bundle/job.ini bundle/output.json bundle/stderr.txt bundle/timing.json"; strings.Join(names, " ") != exp {
+			t.Errorf("bundle contents = %v, expected %s", names, exp)
+		}
+		if !strings.Contains(contents["bundle/stderr.txt"], "libaio not loaded") ||
+			!strings.Contains(contents["bundle/timing.json"], "exit status 1") {
+			t.Errorf("expected failure recorded in bundle: %v", contents)
+		}
+	})
+
+	t.Run("within probe directory", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "dir")
+		_, err := probe.Run(context.Background(),
+			append(opts, probe.WithDirectory(dir), probe.WithArtifacts(filepath.Join(dir, "artifacts")))...)
+		if err == nil || !strings.Contains(err.Error(), "within the probe directory") {
+			t.Errorf("expected error, got %v", err)
+		}
+	})
+}
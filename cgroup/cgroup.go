@@ -0,0 +1,162 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package cgroup enforces a fraction of measured disk capacity on a workload,
+// by configuring cgroup v2 I/O limits (io.max).
+package cgroup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/internal/cgroupfs"
+)
+
+// sysfsBlock is where block devices are found in sysfs; overridden in tests.
+var sysfsBlock = "/sys/dev/block"
+
+// LimitsFrom returns limits for the given device set to the given fraction
+// (in (0, 1]) of the capacity measured by the given results, using the latest
+// complete result of each kind. Dimensions without results are left
+// unlimited. Results need to have been probed on the device itself.
+func LimitsFrom(device probe.Device, fraction float64, results ...probe.Result) (probe.IOLimits, error) {
+	if fraction <= 0 || fraction > 1 {
+		return probe.IOLimits{}, fmt.Errorf("invalid fraction: %v", fraction)
+	}
+	for _, r := range results {
+		if r.Device.ID() != device.ID() {
+			return probe.IOLimits{}, fmt.Errorf("%s result probed on %s, not %s", r.Kind, r.Device.ID(), device.ID())
+		}
+	}
+	var l probe.IOLimits
+	for kind, r := range probe.LatestByKind(results...) {
+		v := uint64(float64(r.Value) * fraction)
+		if v == 0 {
+			v = 1 // zero would mean unlimited
+		}
+		switch kind {
+		case probe.ReadBandwidth:
+			l.ReadBPS = v
+		case probe.WriteBandwidth:
+			l.WriteBPS = v
+		case probe.ReadIOPS:
+			l.ReadIOPS = v
+		case probe.WriteIOPS:
+			l.WriteIOPS = v
+		}
+	}
+	return l, nil
+}
+
+// Plan is an update to a cgroup's io.max for a single device. Use String to
+// see what would be written (i.e. for a dry run), Apply to write it, and
+// Rollback to restore what was there before. Only the limits the plan sets
+// are written; others (e.g. a write limit, when only limiting reads) are left
+// as configured.
+type Plan struct {
+	// Path is the cgroup directory, e.g. /sys/fs/cgroup/workload.slice.
+	Path string
+	// MajMin identifies the (whole disk) device being limited.
+	MajMin string
+	// Limits are what's applied; Previous is what was configured before.
+	Limits, Previous probe.IOLimits
+}
+
+// NewPlan prepares to apply the given limits for the given device to the
+// cgroup at the given path, recording what's currently configured. Limits
+// apply to whole disks, so partitions are resolved to their disk.
+func NewPlan(path string, device probe.Device, limits probe.IOLimits) (Plan, error) {
+	majmin, err := wholeDisk(device)
+	if err != nil {
+		return Plan{}, err
+	}
+	data, err := os.ReadFile(filepath.Join(path, "io.max"))
+	if err != nil {
+		return Plan{}, err
+	}
+	current, err := cgroupfs.ParseIOMax(data)
+	if err != nil {
+		return Plan{}, err
+	}
+	if limits == (probe.IOLimits{}) {
+		return Plan{}, fmt.Errorf("no limits to apply")
+	}
+	return Plan{
+		Path:     path,
+		MajMin:   majmin,
+		Limits:   limits,
+		Previous: fromCgroupfs(current[majmin]),
+	}, nil
+}
+
+// String returns the io.max entry Apply writes.
+func (p Plan) String() string {
+	return cgroupfs.FormatIOMaxKeys(p.MajMin, toCgroupfs(p.Limits), p.keys()...)
+}
+
+// Apply writes the planned limits.
+func (p Plan) Apply() error {
+	return p.write(p.Limits)
+}
+
+// Rollback restores the limits configured before the plan was applied.
+func (p Plan) Rollback() error {
+	return p.write(p.Previous)
+}
+
+// keys returns the io.max keys the plan sets.
+func (p Plan) keys() []string {
+	var keys []string
+	for _, key := range cgroupfs.Keys {
+		if toCgroupfs(p.Limits).Get(key) != 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (p Plan) write(l probe.IOLimits) error {
+	f, err := os.OpenFile(filepath.Join(p.Path, "io.max"), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	// io.max takes a single entry per write.
+	if _, err := f.WriteString(cgroupfs.FormatIOMaxKeys(p.MajMin, toCgroupfs(l), p.keys()...)); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("writing %s: %w", f.Name(), err)
+	}
+	return f.Close()
+}
+
+// wholeDisk returns MAJ:MIN for the disk the given device is on; partitions
+// are resolved to their parent.
+func wholeDisk(device probe.Device) (string, error) {
+	if device.Major == 0 {
+		return "", fmt.Errorf("not a block device: %s", device)
+	}
+	if device.Partition == 0 {
+		return device.MajMin(), nil
+	}
+	return cgroupfs.WholeDisk(sysfsBlock, device.MajMin())
+}
+
+func toCgroupfs(l probe.IOLimits) cgroupfs.Limits {
+	return cgroupfs.Limits{RBPS: l.ReadBPS, WBPS: l.WriteBPS, RIOPS: l.ReadIOPS, WIOPS: l.WriteIOPS}
+}
+
+func fromCgroupfs(l cgroupfs.Limits) probe.IOLimits {
+	return probe.IOLimits{ReadBPS: l.RBPS, WriteBPS: l.WBPS, ReadIOPS: l.RIOPS, WriteIOPS: l.WIOPS}
+}
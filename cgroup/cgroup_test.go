@@ -0,0 +1,155 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cgroup
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/irfansharif/probe"
+)
+
+// fakeFS sets up a fake sysfs, with partition 259:1 on disk 259:0, and a fake
+// cgroup with the given io.max contents. It returns the cgroup's path.
+func fakeFS(t *testing.T, iomax string) string {
+	tmp := t.TempDir()
+	disk := filepath.Join(tmp, "sys", "devices", "nvme0n1")
+	if err := os.MkdirAll(filepath.Join(disk, "nvme0n1p1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(disk, "dev"), []byte("259:0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	block := filepath.Join(tmp, "sys", "dev", "block")
+	if err := os.MkdirAll(block, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(disk, "nvme0n1p1"), filepath.Join(block, "259:1")); err != nil {
+		t.Fatal(err)
+	}
+	prev := sysfsBlock
+	sysfsBlock = block
+	t.Cleanup(func() { sysfsBlock = prev })
+
+	cgroup := filepath.Join(tmp, "cgroup", "workload.slice")
+	if err := os.MkdirAll(cgroup, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cgroup, "io.max"), []byte(iomax), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return cgroup
+}
+
+func TestPlan(t *testing.T) {
+	cgroup := fakeFS(t, "8:0 rbps=max wbps=max riops=100 wiops=max\n259:0 rbps=max wbps=1048576 riops=max wiops=max\n")
+	device := probe.Device{Major: 259, Minor: 1, Name: "nvme0n1p1", Partition: 1}
+
+	limits, err := LimitsFrom(device, 0.5,
+		probe.Result{Kind: probe.WriteBandwidth, Value: 400 << 20, Device: device},
+		probe.Result{Kind: probe.ReadIOPS, Value: 100000, Device: device},
+		probe.Result{Kind: probe.ReadIOPS, Value: 1, Device: device, Incomplete: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (probe.IOLimits{WriteBPS: 200 << 20, ReadIOPS: 50000}); limits != exp {
+		t.Fatalf("limits = %+v, expected %+v", limits, exp)
+	}
+
+	plan, err := NewPlan(cgroup, device, limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (probe.IOLimits{WriteBPS: 1 << 20}); plan.Previous != exp {
+		t.Errorf("previous = %+v, expected %+v", plan.Previous, exp)
+	}
+	// Dry run.
+	if exp := "259:0 wbps=209715200 riops=50000"; plan.String() != exp {
+		t.Errorf("plan = %q, expected %q", plan.String(), exp)
+	}
+
+	iomax := filepath.Join(cgroup, "io.max")
+	read := func() string {
+		data, err := os.ReadFile(iomax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	if err := plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(); got != plan.String() {
+		t.Errorf("io.max = %q, expected %q", got, plan.String())
+	}
+	if err := plan.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "259:0 wbps=1048576 riops=max"; read() != exp {
+		t.Errorf("io.max = %q, expected %q", read(), exp)
+	}
+
+	if _, err := NewPlan(cgroup, device, probe.IOLimits{}); err == nil {
+		t.Error("expected error for plan without limits")
+	}
+	if _, err := NewPlan(cgroup, probe.Device{}, limits); err == nil {
+		t.Error("expected error for non-block device")
+	}
+	if _, err := LimitsFrom(device, 0); err == nil {
+		t.Error("expected error for zero fraction")
+	}
+	other := probe.Device{Major: 8, Minor: 0, Name: "sda"}
+	if _, err := LimitsFrom(device, 0.5,
+		probe.Result{Kind: probe.ReadIOPS, Value: 100000, Device: device},
+		probe.Result{Kind: probe.WriteIOPS, Value: 100000, Device: other},
+	); err == nil || !strings.Contains(err.Error(), "name:sda") {
+		t.Errorf("expected error for result probed on another device, got %v", err)
+	}
+}
+
+func TestPlanLeavesOtherLimits(t *testing.T) {
+	// An operator already limits writes; limiting reads shouldn't lift that.
+	cgroup := fakeFS(t, "259:0 rbps=max wbps=1048576 riops=max wiops=max\n")
+	device := probe.Device{Major: 259, Minor: 0, Name: "nvme0n1"}
+
+	plan, err := NewPlan(cgroup, device, probe.IOLimits{ReadBPS: 100 << 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iomax := filepath.Join(cgroup, "io.max")
+	for _, step := range []struct {
+		apply func() error
+		exp   string
+	}{
+		{plan.Apply, "259:0 rbps=104857600"},
+		{plan.Rollback, "259:0 rbps=max"},
+	} {
+		if err := step.apply(); err != nil {
+			t.Fatal(err)
+		}
+		// The fake io.max is a regular file, so it holds exactly what was
+		// written; the kernel would merge it into the existing entry.
+		data, err := os.ReadFile(iomax)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != step.exp {
+			t.Errorf("io.max = %q, expected %q", data, step.exp)
+		}
+	}
+}
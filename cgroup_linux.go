@@ -0,0 +1,45 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import "github.com/irfansharif/probe/internal/cgroupfs"
+
+// procSelf and sysfsBlock are where the calling process's cgroup membership
+// and block devices are found; overridden in tests.
+var (
+	procSelf   = "/proc/self"
+	sysfsBlock = "/sys/dev/block"
+)
+
+// cgroupLimits returns the cgroup I/O limits in effect for the calling process
+// on the given device.
+func cgroupLimits(device Device) (IOLimits, error) {
+	if device.Major == 0 {
+		return IOLimits{}, nil // virtual filesystem
+	}
+	majmin := device.MajMin()
+	if device.Partition != 0 {
+		// Limits are configured on whole disks.
+		var err error
+		if majmin, err = cgroupfs.WholeDisk(sysfsBlock, majmin); err != nil {
+			return IOLimits{}, err
+		}
+	}
+	l, err := cgroupfs.Effective(procSelf, majmin)
+	if err != nil {
+		return IOLimits{}, err
+	}
+	return IOLimits{ReadBPS: l.RBPS, WriteBPS: l.WBPS, ReadIOPS: l.RIOPS, WriteIOPS: l.WIOPS}, nil
+}
@@ -0,0 +1,120 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe/fiotest"
+	"github.com/irfansharif/probe/internal/cgroupfs"
+)
+
+func TestCgroupLimits(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	device, err := DeviceOf(dir)
+	if err != nil || device.Major == 0 {
+		t.Skipf("temporary directory not on a block device (%v)", err)
+	}
+	majmin := device.MajMin()
+	if device.Partition != 0 {
+		if majmin, err = cgroupfs.WholeDisk(sysfsBlock, majmin); err != nil {
+			t.Skip(err)
+		}
+	}
+
+	// Fake a process in /workload.slice/probe.service, with limits configured
+	// on both it and its parent.
+	fake := t.TempDir()
+	root := filepath.Join(fake, "cgroup")
+	write := func(path, data string) {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(fake, "proc", "mountinfo"),
+		fmt.Sprintf("42 32 0:38 / %s rw,relatime - cgroup2 cgroup2 rw\n", root))
+	write(filepath.Join(fake, "proc", "cgroup"), "0::/workload.slice/probe.service\n")
+	write(filepath.Join(root, "workload.slice", "io.max"),
+		fmt.Sprintf("%s rbps=max wbps=%d riops=max wiops=max\n", majmin, 100<<20))
+	write(filepath.Join(root, "workload.slice", "probe.service", "io.max"),
+		fmt.Sprintf("%s rbps=max wbps=%d riops=max wiops=5000\n", majmin, 200<<20))
+	prev := procSelf
+	procSelf = filepath.Join(fake, "proc")
+	defer func() { procSelf = prev }()
+
+	limits, err := CgroupLimits(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (IOLimits{WriteBPS: 100 << 20, WriteIOPS: 5000}); limits != exp {
+		t.Fatalf("limits = %+v, expected %+v", limits, exp)
+	}
+
+	run := func(kind Kind, opts ...Option) Result {
+		runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{WriteBW: 98 << 20, ReadBW: 1 << 30})}
+		opts = append([]Option{
+			WithKind(kind),
+			WithDirectory(dir),
+			WithDuration(10 * time.Second),
+			WithSize(16 << 20),
+			WithReservedSpace(0),
+			WithRunner(runner),
+		}, opts...)
+		res, err := Run(context.Background(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ran := len(runner.Calls()) == 1; ran == res.FromCgroup {
+			t.Errorf("%s: ran fio = %t, from cgroup = %t", kind, ran, res.FromCgroup)
+		}
+		if res.CgroupLimits != limits {
+			t.Errorf("%s: cgroup limits = %+v, expected %+v", kind, res.CgroupLimits, limits)
+		}
+		return res
+	}
+
+	// Probes near the limit say so.
+	res := run(WriteBandwidth)
+	if len(res.Diagnostics) != 1 || !strings.Contains(res.Diagnostics[0].Message, "cgroup limit") {
+		t.Errorf("expected diagnostic about the cgroup limit, got %+v", res.Diagnostics)
+	}
+	if res := run(ReadBandwidth); len(res.Diagnostics) != 0 {
+		t.Errorf("unexpected diagnostics: %+v", res.Diagnostics)
+	}
+
+	// The fast path returns the limit without probing, if it's below the
+	// device's capacity.
+	if res := run(WriteBandwidth, WithCgroupFastPath(0)); !res.FromCgroup || res.Value != 100<<20 {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if res := run(WriteIOPS, WithCgroupFastPath(4000)); res.FromCgroup {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if res := run(ReadIOPS, WithCgroupFastPath(0)); res.FromCgroup {
+		t.Errorf("unexpected result: %+v", res)
+	}
+}
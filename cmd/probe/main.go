@@ -0,0 +1,165 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Command probe probes disks for their capacity.
+//
+// Usage:
+//
+//	probe run [-profile name|file] [-artifacts path] -dir path
+//	probe serve [-addr 127.0.0.1:8080] [-history dir] -dir path [-dir path ...]
+//
+// The server has no authentication, and runs disk-saturating probes on
+// demand, so it listens on localhost by default. To expose it further, put
+// it behind an authenticating proxy.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/history"
+	"github.com/irfansharif/probe/profile"
+	"github.com/irfansharif/probe/server"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "run":
+		err = run(args)
+	case "serve":
+		err = serve(args)
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "probe: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: probe run|serve [flags]\n")
+	os.Exit(2)
+}
+
+// dirs is a repeatable flag.
+type dirs []string
+
+func (d *dirs) String() string { return strings.Join(*d, ",") }
+
+func (d *dirs) Set(v string) error {
+	*d = append(*d, v)
+	return nil
+}
+
+// run runs a single probe, as described by a profile, printing its result as
+// JSON.
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	name := fs.String("profile", "read-bandwidth",
+		fmt.Sprintf("profile file, or one of the presets: %s", strings.Join(profile.Presets(), ", ")))
+	dir := fs.String("dir", "", "directory to probe in, cleared before and after the probe")
+	artifacts := fs.String("artifacts", "", "directory or tarball (.tar, .tar.gz) to write an artifact bundle to (optional)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("run: -dir is required")
+	}
+	p, err := profile.Load(*name)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	opts := []probe.Option{probe.WithDirectory(*dir), probe.WithLoggingTo(os.Stderr)}
+	if *artifacts != "" {
+		opts = append(opts, probe.WithArtifacts(*artifacts))
+	}
+	res, err := p.Run(ctx, opts...)
+	if err != nil && !res.Incomplete {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(res); err != nil {
+		return err
+	}
+	return err
+}
+
+func serve(args []string) error {
+	var directories dirs
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080",
+		"address to listen on; the server is unauthenticated, so put it behind auth before exposing it beyond localhost")
+	historyDir := fs.String("history", "", "directory to persist results in (optional)")
+	fs.Var(&directories, "dir", "directory probes may run in, cleared before and after each probe (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(directories) == 0 {
+		return errors.New("serve: at least one -dir is required")
+	}
+	if !probe.Supported() {
+		return errors.New("serve: fio not found in $PATH")
+	}
+
+	logger := log.New(os.Stderr, "[probe] ", log.LstdFlags|log.Lmsgprefix)
+	cfg := server.Config{
+		Directories: directories,
+		Options:     []probe.Option{probe.WithLoggingTo(logger.Writer())},
+	}
+	if *historyDir != "" {
+		store, err := history.Open(*historyDir)
+		if err != nil {
+			return err
+		}
+		cfg.Store = store
+	}
+	srv := server.New(cfg)
+	defer srv.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{Addr: *addr, Handler: srv, ReadHeaderTimeout: 10 * time.Second}
+	errCh := make(chan error, 1)
+	go func() { errCh <- httpServer.ListenAndServe() }()
+	logger.Printf("serving on %s, probing in %s", *addr, directories.String())
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return httpServer.Shutdown(shutdownCtx)
+}
@@ -0,0 +1,59 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import "fmt"
+
+// Device identifies the block device backing a directory.
+type Device struct {
+	// Major and Minor are the device numbers, i.e. MAJ:MIN.
+	Major uint32 `json:"major"`
+	Minor uint32 `json:"minor"`
+	// Name is the kernel's name for the device (e.g. "nvme0n1p1"), if any.
+	// Directories on virtual filesystems (e.g. overlayfs, tmpfs) have none.
+	Name string `json:"name,omitempty"`
+	// Partition is the partition number, if the device is a partition.
+	Partition int `json:"partition,omitempty"`
+	// Serial is the (underlying disk's) serial number, if known.
+	Serial string `json:"serial,omitempty"`
+}
+
+// ID returns a stable identifier for the device, preferring what's least
+// likely to change across reboots: device numbers and names are assigned in
+// discovery order, serial numbers are not.
+func (d Device) ID() string {
+	switch {
+	case d.Serial != "" && d.Partition != 0:
+		return fmt.Sprintf("serial:%s/part:%d", d.Serial, d.Partition)
+	case d.Serial != "":
+		return fmt.Sprintf("serial:%s", d.Serial)
+	case d.Name != "":
+		return fmt.Sprintf("name:%s", d.Name)
+	default:
+		return fmt.Sprintf("dev:%s", d.MajMin())
+	}
+}
+
+// MajMin returns the device numbers formatted as MAJ:MIN.
+func (d Device) MajMin() string {
+	return fmt.Sprintf("%d:%d", d.Major, d.Minor)
+}
+
+func (d Device) String() string {
+	if d.Name != "" {
+		return fmt.Sprintf("%s (%s)", d.Name, d.MajMin())
+	}
+	return d.MajMin()
+}
@@ -0,0 +1,82 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DeviceOf returns the block device backing the given directory. Directories
+// that don't exist (e.g. probe directories, which are removed once probed) are
+// attributed to the device of their nearest existing parent, where they'd be
+// created.
+func DeviceOf(dir string) (Device, error) {
+	var st syscall.Stat_t
+	for {
+		err := syscall.Stat(dir, &st)
+		if err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if !errors.Is(err, syscall.ENOENT) || parent == dir {
+			return Device{}, err
+		}
+		dir = parent
+	}
+	// See <sys/sysmacros.h>.
+	dev := uint64(st.Dev)
+	d := Device{
+		Major: uint32((dev>>8)&0xfff | (dev>>32)&^0xfff),
+		Minor: uint32(dev&0xff | (dev>>12)&^0xff),
+	}
+
+	sysfs := filepath.Join("/sys/dev/block", d.MajMin())
+	uevent, err := os.Open(filepath.Join(sysfs, "uevent"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return d, nil // virtual filesystem
+		}
+		return Device{}, err
+	}
+	defer func() { _ = uevent.Close() }()
+	scanner := bufio.NewScanner(uevent)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), "=")
+		switch key {
+		case "DEVNAME":
+			d.Name = value
+		case "PARTN":
+			d.Partition, _ = strconv.Atoi(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Device{}, fmt.Errorf("reading %s: %w", uevent.Name(), err)
+	}
+	if d.Name != "" {
+		// Best-effort; serial numbers come from udev, which may not be
+		// running (e.g. in containers).
+		d.Serial, _ = disk.SerialNumber(filepath.Join("/dev", d.Name))
+	}
+	return d, nil
+}
@@ -0,0 +1,28 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build !linux
+
+package probe
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// DeviceOf returns the block device backing the given directory. It's only
+// implemented on Linux.
+func DeviceOf(dir string) (Device, error) {
+	return Device{}, fmt.Errorf("device identification unsupported on %s", runtime.GOOS)
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// RandomDistribution is how random I/O offsets are distributed across the
+// probe's files, i.e. fio's random_distribution. The zero value is uniform.
+// Skewed distributions concentrate I/O on hot regions, like real key-value
+// workloads, which affects device caches and FTL behavior.
+type RandomDistribution string
+
+// Uniform distributes offsets uniformly, which is fio's default.
+const Uniform RandomDistribution = ""
+
+// Zipf returns a Zipf distribution with the given exponent (theta), e.g. 1.2.
+// Larger exponents are more skewed.
+func Zipf(theta float64) RandomDistribution {
+	return RandomDistribution("zipf:" + formatFloat(theta))
+}
+
+// Pareto returns a Pareto distribution with the given power (h, in (0, 1)),
+// e.g. 0.9. Smaller powers are more skewed.
+func Pareto(h float64) RandomDistribution {
+	return RandomDistribution("pareto:" + formatFloat(h))
+}
+
+// Normal returns a normal distribution centered on the middle of each file,
+// with the given standard deviation as a percentage of the file (or zero for
+// fio's default).
+func Normal(stddevPercent float64) RandomDistribution {
+	if stddevPercent == 0 {
+		return "normal"
+	}
+	return RandomDistribution("normal:" + formatFloat(stddevPercent))
+}
+
+// Zone is part of a zoned distribution: AccessPercent of I/O goes to the next
+// RangePercent of each file. fio only takes whole percentages.
+type Zone struct {
+	AccessPercent, RangePercent int
+}
+
+// Zoned returns a distribution with the given zones, laid out in order from
+// the start of each file, e.g. 60% of I/O to the first 10%, 30% to the next
+// 20%, and so on. Both percentages need to add up to 100 across zones.
+func Zoned(zones ...Zone) RandomDistribution {
+	parts := make([]string, len(zones))
+	for i, z := range zones {
+		parts[i] = fmt.Sprintf("%d/%d", z.AccessPercent, z.RangePercent)
+	}
+	return RandomDistribution("zoned:" + strings.Join(parts, ":"))
+}
+
+// ParseRandomDistribution parses and validates a distribution in fio's
+// syntax, e.g. "zipf:1.2", "pareto:0.9", "normal", "normal:5" or
+// "zoned:60/10:30/20:10/70". "random" is uniform.
+func ParseRandomDistribution(s string) (RandomDistribution, error) {
+	d := RandomDistribution(strings.TrimSpace(s))
+	if d == "random" {
+		d = Uniform
+	}
+	return d, d.validate()
+}
+
+func (d RandomDistribution) validate() error {
+	if d == Uniform {
+		return nil
+	}
+	name, spec, _ := strings.Cut(string(d), ":")
+	invalid := func(format string, args ...interface{}) error {
+		return fmt.Errorf("invalid random distribution %q: %s", d, fmt.Sprintf(format, args...))
+	}
+	param := func() (float64, error) {
+		f, err := strconv.ParseFloat(spec, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, invalid("want a number after %q", name+":")
+		}
+		return f, nil
+	}
+	switch name {
+	case "zipf":
+		theta, err := param()
+		if err != nil {
+			return err
+		}
+		if theta <= 0 || theta == 1 {
+			return invalid("theta must be positive and not 1")
+		}
+	case "pareto":
+		h, err := param()
+		if err != nil {
+			return err
+		}
+		if h <= 0 || h >= 1 {
+			return invalid("h must be in (0, 1)")
+		}
+	case "normal":
+		if spec == "" {
+			return nil
+		}
+		dev, err := param()
+		if err != nil {
+			return err
+		}
+		if dev < 0 || dev > 100 {
+			return invalid("standard deviation must be a percentage")
+		}
+	case "zoned":
+		var access, span int
+		for _, zone := range strings.Split(spec, ":") {
+			// fio parses zone percentages as integers, truncating the rest.
+			a, r, ok := strings.Cut(zone, "/")
+			ai, aerr := strconv.Atoi(a)
+			ri, rerr := strconv.Atoi(r)
+			if !ok || aerr != nil || rerr != nil || ai < 0 || ri < 0 {
+				return invalid("want zones of the form access%%/range%%, in whole percentages, got %q", zone)
+			}
+			access, span = access+ai, span+ri
+		}
+		if access != 100 || span != 100 {
+			return invalid("zone percentages must add up to 100 (got %d%% of I/O across %d%% of the file)",
+				access, span)
+		}
+	default:
+		return invalid("want one of zipf, pareto, normal or zoned")
+	}
+	return nil
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
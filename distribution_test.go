@@ -0,0 +1,102 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/fiotest"
+)
+
+func TestRandomDistribution(t *testing.T) {
+	for _, tc := range []struct {
+		dist probe.RandomDistribution
+		exp  string
+	}{
+		{probe.Zipf(1.2), "zipf:1.2"},
+		{probe.Pareto(0.9), "pareto:0.9"},
+		{probe.Normal(0), "normal"},
+		{probe.Normal(5), "normal:5"},
+		{probe.Zoned(probe.Zone{60, 10}, probe.Zone{30, 20}, probe.Zone{10, 70}), "zoned:60/10:30/20:10/70"},
+	} {
+		if string(tc.dist) != tc.exp {
+			t.Errorf("got %q, expected %q", tc.dist, tc.exp)
+		}
+		if _, err := probe.ParseRandomDistribution(tc.exp); err != nil {
+			t.Errorf("parsing %q: %v", tc.exp, err)
+		}
+	}
+	if d, err := probe.ParseRandomDistribution("random"); err != nil || d != probe.Uniform {
+		t.Errorf("expected random to be uniform, got %q, %v", d, err)
+	}
+
+	for _, tc := range []struct {
+		dist string
+		err  string
+	}{
+		{"zipf:1", "theta"},
+		{"zipf", "want a number"},
+		{"pareto:1.5", "in (0, 1)"},
+		{"normal:x", "want a number"},
+		{"zoned:60/10:30/20", "add up to 100"},
+		{"zoned:60-10:40/90", "access%/range%"},
+		{"zoned:60.5/10:39.5/90", "whole percentages"},
+		{"gauss:1", "want one of"},
+	} {
+		if _, err := probe.ParseRandomDistribution(tc.dist); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("parsing %q: expected error containing %q, got %v", tc.dist, tc.err, err)
+		}
+	}
+}
+
+func TestFakeRandomDistribution(t *testing.T) {
+	runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{})}
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.ReadIOPS))
+
+	res, err := probe.Run(context.Background(), append(opts,
+		probe.WithRandomDistribution(probe.Zipf(1.2)),
+		probe.WithPercentageRandom(80))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := strings.Join(runner.Calls()[0], " ")
+	if !strings.Contains(cmd, "--random_distribution=zipf:1.2") || !strings.Contains(cmd, "--percentage_random=80") {
+		t.Errorf("expected random distribution in: %s", cmd)
+	}
+	if exp := "/dist=zipf:1.2/random=80%"; !strings.Contains(res.Config.String(), exp) {
+		t.Errorf("expected %q in config: %s", exp, res.Config)
+	}
+
+	for _, tc := range []struct {
+		opts []probe.Option
+		err  string
+	}{
+		{[]probe.Option{probe.WithRandomDistribution("zipf:0")}, "theta"},
+		{[]probe.Option{probe.WithPercentageRandom(101)}, "invalid random percentage"},
+		{[]probe.Option{probe.WithReadWrite(probe.SeqRead), probe.WithRandomDistribution(probe.Pareto(0.9))},
+			"sequential I/O pattern"},
+	} {
+		_, err := probe.Run(context.Background(), append(opts, tc.opts...)...)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected error containing %q, got %v", tc.err, err)
+		}
+	}
+	if len(runner.Calls()) != 1 {
+		t.Errorf("expected fio not to run with invalid distributions")
+	}
+}
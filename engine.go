@@ -0,0 +1,166 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// IOEngine is the fio I/O engine used to issue I/O, i.e. how the probe
+// submits requests to the kernel. See 'fio --enghelp'.
+type IOEngine string
+
+const (
+	IOUring  IOEngine = "io_uring"
+	LibAIO   IOEngine = "libaio"
+	PosixAIO IOEngine = "posixaio"
+	PVSync2  IOEngine = "pvsync2"
+	PSync    IOEngine = "psync"
+	Sync     IOEngine = "sync"
+	MMap     IOEngine = "mmap"
+)
+
+// preferredEngines lists engines in the order we'd rather use them; the
+// asynchronous ones are able to keep the configured I/O depth in flight.
+var preferredEngines = []IOEngine{IOUring, LibAIO, PosixAIO, PVSync2, PSync, Sync, MMap}
+
+// defaultIOEngine is what's used when no engine is explicitly configured.
+func defaultIOEngine() IOEngine {
+	if runtime.GOOS == "darwin" {
+		return PosixAIO
+	}
+	return LibAIO
+}
+
+// Caps describes what the installed fio is capable of.
+type Caps struct {
+	// Version is fio's self-reported version, e.g. "fio-3.30".
+	Version string
+	// Engines are the I/O engines fio was built with, less those that failed
+	// a trial run.
+	Engines []IOEngine
+}
+
+// Supports returns whether the given I/O engine is available.
+func (c Caps) Supports(engine IOEngine) bool {
+	for _, e := range c.Engines {
+		if e == engine {
+			return true
+		}
+	}
+	return false
+}
+
+// BestEngine returns the most preferred I/O engine that's available, and
+// false if none of the engines we know about are.
+func (c Caps) BestEngine() (IOEngine, bool) {
+	for _, e := range preferredEngines {
+		if c.Supports(e) {
+			return e, true
+		}
+	}
+	return "", false
+}
+
+// Capabilities probes fio for its version and the I/O engines it's able to
+// use, running it with the configured runner (see WithRunner); other options
+// are ignored. It errors out if fio is not installed.
+func Capabilities(ctx context.Context, opts ...Option) (Caps, error) {
+	o := &options{LoggingTo: io.Discard, Runner: ExecRunner{}}
+	for _, opt := range opts {
+		opt(o)
+	}
+	version, _, err := o.Runner.Run(ctx, []string{"--version"})
+	if err != nil {
+		return Caps{}, err
+	}
+	enghelp, _, err := o.Runner.Run(ctx, []string{"--enghelp"})
+	if err != nil {
+		return Caps{}, err
+	}
+	caps := Caps{Version: strings.TrimSpace(string(version))}
+	for _, engine := range parseEngHelp(enghelp) {
+		// fio lists the engines it was built with, not the ones that work:
+		// io_uring is often disabled (kernel.io_uring_disabled), or blocked
+		// by seccomp in containers, so is only listed if a trial run works.
+		if engine == IOUring {
+			if err := o.tryEngine(ctx, engine); err != nil {
+				_, _ = fmt.Fprintf(o.LoggingTo, "unable to use %s: %s\n", engine, err)
+				continue
+			}
+		}
+		caps.Engines = append(caps.Engines, engine)
+	}
+	return caps, nil
+}
+
+// tryEngine has fio issue a few reads with the given engine, to check that
+// it's usable.
+func (o *options) tryEngine(ctx context.Context, engine IOEngine) error {
+	dir, err := os.MkdirTemp("", "probe-engine")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	args, err := commandLine(NewJob("engine").
+		Directory(dir).
+		Size(64 << 10). // 64KiB
+		IOEngine(engine).
+		ReadWrite(SeqRead).
+		BlockSize(4 << 10). // 4KiB
+		Flag("thread"))
+	if err != nil {
+		return err
+	}
+	run, err := o.execute(ctx, args)
+	if err != nil {
+		return err
+	}
+	for _, job := range run.Output.Jobs {
+		if job.Error != 0 {
+			return fmt.Errorf("fio job failed with errno %d", int(job.Error))
+		}
+	}
+	return nil
+}
+
+// parseEngHelp parses the output of 'fio --enghelp', which looks as follows:
+//
+//	Available IO engines:
+//		cpuio
+//		mmap
+//		sync
+//		...
+func parseEngHelp(output []byte) []IOEngine {
+	var engines []IOEngine
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "\t") && !strings.HasPrefix(line, " ") {
+			continue // header
+		}
+		if engine := strings.TrimSpace(line); engine != "" {
+			engines = append(engines, IOEngine(engine))
+		}
+	}
+	return engines
+}
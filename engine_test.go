@@ -0,0 +1,121 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/irfansharif/probe/fiotest"
+)
+
+// enghelp is what 'fio --enghelp' printed for fio-3.35, built on Linux.
+const enghelp = `Available IO engines:
+	cpuio
+	mmap
+	sync
+	psync
+	vsync
+	pvsync
+	pvsync2
+	null
+	net
+	netsplice
+	ftruncate
+	filecreate
+	filestat
+	filedelete
+	exec
+	posixaio
+	falloc
+	e4defrag
+	splice
+	mtd
+	sg
+	io_uring
+	io_uring_cmd
+	libaio
+`
+
+func TestParseEngHelp(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		output string
+		exp    []IOEngine
+	}{
+		{"empty", "", nil},
+		{"header only", "Available IO engines:\n", nil},
+		{"spaces", "Available IO engines:\n  sync\n  libaio\n\n", []IOEngine{Sync, LibAIO}},
+		{"fio-3.35", enghelp, []IOEngine{
+			"cpuio", MMap, Sync, PSync, "vsync", "pvsync", PVSync2, "null", "net",
+			"netsplice", "ftruncate", "filecreate", "filestat", "filedelete", "exec",
+			PosixAIO, "falloc", "e4defrag", "splice", "mtd", "sg", IOUring,
+			"io_uring_cmd", LibAIO,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if engines := parseEngHelp([]byte(tc.output)); !reflect.DeepEqual(engines, tc.exp) {
+				t.Errorf("engines = %v, expected %v", engines, tc.exp)
+			}
+		})
+	}
+}
+
+// engineRunner fakes fio's --version and --enghelp, and trial runs of I/O
+// engines, failing trial runs of the given one.
+type engineRunner struct {
+	fiotest.Runner
+	failing IOEngine
+}
+
+func (r *engineRunner) Run(ctx context.Context, args []string) ([]byte, []byte, error) {
+	switch cmd := strings.Join(args, " "); {
+	case cmd == "--version":
+		return []byte("fio-3.35\n"), nil, nil
+	case cmd == "--enghelp":
+		return []byte(enghelp), nil, nil
+	case strings.Contains(cmd, "--ioengine="+string(r.failing)+" "):
+		return nil, []byte("fio: io_uring_queue_init: Operation not permitted\n"), errors.New("exit status 1")
+	default:
+		return r.Runner.Run(ctx, args)
+	}
+}
+
+func TestCapabilitiesTrial(t *testing.T) {
+	runner := &engineRunner{Runner: fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{ReadIOPS: 16})}}
+	caps, err := Capabilities(context.Background(), WithRunner(runner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best, ok := caps.BestEngine(); caps.Version != "fio-3.35" || !ok || best != IOUring {
+		t.Errorf("unexpected capabilities: %+v (best = %s)", caps, best)
+	}
+	if calls := runner.Calls(); len(calls) != 1 || !strings.Contains(strings.Join(calls[0], " "), "--ioengine=io_uring") {
+		t.Errorf("expected a single trial run of io_uring, got %q", calls)
+	}
+
+	// Engines fio was built with but can't use are left out.
+	runner = &engineRunner{failing: IOUring}
+	caps, err = Capabilities(context.Background(), WithRunner(runner))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if best, ok := caps.BestEngine(); caps.Supports(IOUring) || !ok || best != LibAIO {
+		t.Errorf("unexpected capabilities: %+v (best = %s)", caps, best)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package fiotest provides test doubles for fio, to test code that depends on
+// probes without issuing any real I/O.
+package fiotest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Runner is an in-process fake for fio, implementing probe.Runner. It returns
+// canned output and records the arguments it was invoked with.
+type Runner struct {
+	// Stdout and Stderr are returned as what fio wrote out. See Output for
+	// generating fio's JSON output.
+	Stdout, Stderr []byte
+	// Err, if set, is returned as fio's exit error.
+	Err error
+	// Delay simulates fio's runtime. If the context is cancelled in the
+	// interim, the canned output is returned alongside the context's error,
+	// like an interrupted fio would.
+	Delay time.Duration
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+// Run implements the probe.Runner interface.
+func (r *Runner) Run(ctx context.Context, args []string) (stdout, stderr []byte, err error) {
+	r.mu.Lock()
+	r.calls = append(r.calls, append([]string(nil), args...))
+	r.mu.Unlock()
+
+	if r.Delay > 0 {
+		timer := time.NewTimer(r.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return r.Stdout, r.Stderr, ctx.Err()
+		}
+	}
+	return r.Stdout, r.Stderr, r.Err
+}
+
+// Calls returns the arguments of every invocation so far.
+func (r *Runner) Calls() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.calls...)
+}
+
+// Stats are the headline numbers reported in canned fio output.
+type Stats struct {
+	ReadBW, WriteBW     uint64 // bytes/s
+	ReadIOPS, WriteIOPS float64
+	// ReadLatency and WriteLatency are completion latency percentiles, keyed
+	// by percentile (e.g. 99.9).
+	ReadLatency, WriteLatency map[float64]time.Duration
+}
+
+// Job is a named job's stats, as reported in canned fio output.
+type Job struct {
+	Name string
+	Stats
+}
+
+// Output generates fio's JSON output (as of fio-3.30) reporting the given
+// stats for a single (group-reported) job.
+func Output(stats Stats) []byte {
+	return Jobs(Job{Name: "fiotest", Stats: stats})
+}
+
+// Jobs generates fio's JSON output (as of fio-3.30) reporting the given jobs'
+// stats separately, as fio does without group reporting.
+func Jobs(jobs ...Job) []byte {
+	rw := func(bw uint64, iops float64, latency map[float64]time.Duration) map[string]interface{} {
+		percentiles := make(map[string]int64)
+		var max time.Duration
+		for p, l := range latency {
+			percentiles[fmt.Sprintf("%f", p)] = l.Nanoseconds()
+			if l > max {
+				max = l
+			}
+		}
+		return map[string]interface{}{
+			"bw_bytes": bw,
+			"bw":       bw >> 10,
+			"iops":     iops,
+			"clat_ns": map[string]interface{}{
+				"max":        max.Nanoseconds(),
+				"percentile": percentiles,
+			},
+		}
+	}
+	var out []interface{}
+	for _, job := range jobs {
+		out = append(out, map[string]interface{}{
+			"jobname": job.Name,
+			"groupid": 0,
+			"read":    rw(job.ReadBW, job.ReadIOPS, job.ReadLatency),
+			"write":   rw(job.WriteBW, job.WriteIOPS, job.WriteLatency),
+		})
+	}
+	data, err := json.MarshalIndent(map[string]interface{}{
+		"fio version": "fio-3.30",
+		"jobs":        out,
+	}, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
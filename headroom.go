@@ -0,0 +1,187 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// Usage is a device's observed I/O, averaged over a sampling window.
+type Usage struct {
+	ReadBandwidth, WriteBandwidth uint64 // bytes/s
+	ReadIOPS, WriteIOPS           uint64
+	// Utilization is the fraction of time the device had I/O in flight, i.e.
+	// %util as reported by iostat. It saturates at 1 well before devices
+	// that serve requests in parallel (SSDs) do.
+	Utilization float64
+	// Window is how long usage was sampled for.
+	Window time.Duration
+}
+
+// MeasureUsage samples the current I/O on the device backing the given
+// directory (from diskstats), over the given window.
+func MeasureUsage(ctx context.Context, dir string, window time.Duration) (Usage, error) {
+	device, err := DeviceOf(dir)
+	if err != nil {
+		return Usage{}, err
+	}
+	if device.Name == "" {
+		return Usage{}, fmt.Errorf("no block device backing %s", dir)
+	}
+	sample := func() (disk.IOCountersStat, time.Time, error) {
+		counters, err := disk.IOCountersWithContext(ctx, device.Name)
+		if err != nil {
+			return disk.IOCountersStat{}, time.Time{}, err
+		}
+		c, ok := counters[device.Name]
+		if !ok {
+			return disk.IOCountersStat{}, time.Time{}, fmt.Errorf("no diskstats found for %s", device.Name)
+		}
+		return c, time.Now(), nil
+	}
+
+	before, start, err := sample()
+	if err != nil {
+		return Usage{}, err
+	}
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		return Usage{}, ctx.Err()
+	}
+	after, end, err := sample()
+	if err != nil {
+		return Usage{}, err
+	}
+
+	elapsed := end.Sub(start)
+	rate := func(before, after uint64) uint64 {
+		return uint64(float64(after-before) / elapsed.Seconds())
+	}
+	busy := time.Duration(after.IoTime-before.IoTime) * time.Millisecond // IoTime is in ms
+	u := Usage{
+		ReadBandwidth:  rate(before.ReadBytes, after.ReadBytes),
+		WriteBandwidth: rate(before.WriteBytes, after.WriteBytes),
+		ReadIOPS:       rate(before.ReadCount, after.ReadCount),
+		WriteIOPS:      rate(before.WriteCount, after.WriteCount),
+		Utilization:    float64(busy) / float64(elapsed),
+		Window:         elapsed,
+	}
+	if u.Utilization > 1 {
+		u.Utilization = 1 // sampling skew
+	}
+	return u, nil
+}
+
+// Headroom is how much more I/O a device is able to take on, given its
+// measured capacity and current usage.
+type Headroom struct {
+	Device Device
+	Usage  Usage
+	// Capacity is the latest complete probe result of each kind.
+	Capacity map[Kind]uint64
+	// Remaining is the headroom along each dimension with a known capacity
+	// (bytes/s for bandwidth, IOPS otherwise), assuming nothing else is added.
+	Remaining map[Kind]uint64
+	// Saturation estimates the fraction of the device's capacity in use.
+	// Reads and writes share the device, so it's the sum of the fractions
+	// of read and write capacity in use, taking the larger of the bandwidth
+	// and IOPS estimates. It can exceed 1 if capacity was underestimated.
+	Saturation float64
+}
+
+// MeasureHeadroom estimates the headroom on the device backing the given
+// directory, sampling its current usage over the given window and combining
+// it with the latest complete results (of any kind) for the same device.
+func MeasureHeadroom(
+	ctx context.Context, dir string, window time.Duration, results ...Result,
+) (Headroom, error) {
+	device, err := DeviceOf(dir)
+	if err != nil {
+		return Headroom{}, err
+	}
+	h := Headroom{
+		Device:    device,
+		Capacity:  make(map[Kind]uint64),
+		Remaining: make(map[Kind]uint64),
+	}
+	var same []Result
+	for _, r := range results {
+		if r.Device.ID() == device.ID() {
+			same = append(same, r)
+		}
+	}
+	latest := LatestByKind(same...)
+	if len(latest) == 0 {
+		return Headroom{}, fmt.Errorf("no results found for %s", device)
+	}
+	for kind, r := range latest {
+		h.Capacity[kind] = r.Value
+	}
+
+	if h.Usage, err = MeasureUsage(ctx, dir, window); err != nil {
+		return Headroom{}, err
+	}
+	h.Saturation = saturation(h.Capacity, h.Usage)
+	for kind, capacity := range h.Capacity {
+		if h.Saturation < 1 {
+			h.Remaining[kind] = uint64(float64(capacity) * (1 - h.Saturation))
+		} else {
+			h.Remaining[kind] = 0
+		}
+	}
+	return h, nil
+}
+
+// saturation estimates the fraction of capacity in use. Along each dimension
+// (bandwidth, IOPS), a read-write mix uses the sum of the fractions of read
+// and write capacity. Dimensions where usage is non-zero but capacity is
+// unknown are ignored.
+func saturation(capacity map[Kind]uint64, usage Usage) float64 {
+	fraction := func(used uint64, kind Kind) (float64, bool) {
+		if used == 0 {
+			return 0, true
+		}
+		c, ok := capacity[kind]
+		if !ok || c == 0 {
+			return 0, false
+		}
+		return float64(used) / float64(c), true
+	}
+	var s float64
+	for _, dim := range [][2]struct {
+		used uint64
+		kind Kind
+	}{
+		{{usage.ReadBandwidth, ReadBandwidth}, {usage.WriteBandwidth, WriteBandwidth}},
+		{{usage.ReadIOPS, ReadIOPS}, {usage.WriteIOPS, WriteIOPS}},
+	} {
+		r, rok := fraction(dim[0].used, dim[0].kind)
+		w, wok := fraction(dim[1].used, dim[1].kind)
+		if !rok || !wok {
+			continue
+		}
+		if r+w > s {
+			s = r + w
+		}
+	}
+	return s
+}
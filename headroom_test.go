@@ -0,0 +1,62 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSaturation(t *testing.T) {
+	capacity := map[Kind]uint64{
+		ReadBandwidth:  400 << 20,
+		WriteBandwidth: 200 << 20,
+		ReadIOPS:       100000,
+		WriteIOPS:      50000,
+	}
+	for _, tc := range []struct {
+		name     string
+		capacity map[Kind]uint64
+		usage    Usage
+		exp      float64
+	}{
+		{"idle", capacity, Usage{}, 0},
+		{
+			// 25% of read bandwidth, 25% of write bandwidth.
+			"bandwidth-bound", capacity,
+			Usage{ReadBandwidth: 100 << 20, WriteBandwidth: 50 << 20, ReadIOPS: 100, WriteIOPS: 50},
+			0.5,
+		},
+		{
+			// Small random reads: 80% of read IOPS, but little bandwidth.
+			"iops-bound", capacity,
+			Usage{ReadBandwidth: 80000 * 4 << 10, ReadIOPS: 80000},
+			0.8,
+		},
+		{
+			// Write IOPS capacity unknown; only bandwidth is considered.
+			"partially-known",
+			map[Kind]uint64{ReadBandwidth: 400 << 20, WriteBandwidth: 200 << 20, ReadIOPS: 100000},
+			Usage{WriteBandwidth: 100 << 20, WriteIOPS: 40000},
+			0.5,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if s := saturation(tc.capacity, tc.usage); math.Abs(s-tc.exp) > 1e-3 {
+				t.Errorf("saturation = %v, expected %v", s, tc.exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package history persists probe results, keyed by device and probe
+// configuration, so that recent measurements can be reused instead of probing
+// again.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/irfansharif/probe"
+)
+
+// fileName is the JSON-lines file results are appended to, within the store's
+// directory.
+const fileName = "results.jsonl"
+
+// Key identifies comparable results: ones measured on the same device, with
+// the same kind of probe and configuration.
+type Key struct {
+	Device string // see probe.Device.ID
+	Kind   probe.Kind
+	Config string // see probe.Config.Key
+}
+
+// KeyOf returns the key for the given result.
+func KeyOf(r probe.Result) Key {
+	return Key{
+		Device: r.Device.ID(),
+		Kind:   r.Kind,
+		Config: r.Config.Key(),
+	}
+}
+
+func (k Key) String() string {
+	return fmt.Sprintf("%s/%s/%s", k.Device, k.Kind, k.Config)
+}
+
+// Store is a local, file-backed history of probe results. It's safe for
+// concurrent use within a process, but not across processes sharing a
+// directory.
+type Store struct {
+	path string
+
+	mu sync.Mutex
+}
+
+// Open opens (creating, if needed) the store under the given directory.
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Store{path: filepath.Join(dir, fileName)}, nil
+}
+
+// Append records the given result.
+func (s *Store) Append(r probe.Result) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	// If we previously crashed mid-append, terminate the partial line so it
+	// doesn't corrupt this one.
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// List returns the results recorded for the given key, oldest first.
+func (s *Store) List(key Key) ([]probe.Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.readLocked()
+	if err != nil {
+		return nil, err
+	}
+	var results []probe.Result
+	for _, r := range all {
+		if KeyOf(r) == key {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// Latest returns the most recent valid result for the given key, i.e. one that
+// ran to completion, no older than maxAge (if non-zero). It returns false if
+// there's none, in which case the caller is expected to probe afresh.
+func (s *Store) Latest(key Key, maxAge time.Duration) (probe.Result, bool, error) {
+	results, err := s.List(key)
+	if err != nil {
+		return probe.Result{}, false, err
+	}
+	for i := len(results) - 1; i >= 0; i-- {
+		r := results[i]
+		if r.Incomplete {
+			continue
+		}
+		if maxAge != 0 && time.Since(r.Start) > maxAge {
+			continue
+		}
+		return r, true, nil
+	}
+	return probe.Result{}, false, nil
+}
+
+// Keys returns all keys with recorded results.
+func (s *Store) Keys() ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.readLocked()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[Key]struct{})
+	var keys []Key
+	for _, r := range all {
+		key := KeyOf(r)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys, nil
+}
+
+// Expire removes results that started before the given time, returning how
+// many were removed.
+func (s *Store) Expire(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all, err := s.readLocked()
+	if err != nil {
+		return 0, err
+	}
+	var retained []probe.Result
+	for _, r := range all {
+		if !r.Start.Before(before) {
+			retained = append(retained, r)
+		}
+	}
+	if len(retained) == len(all) {
+		return 0, nil
+	}
+
+	// Rewrite the file atomically, so a crash mid-way doesn't lose history.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), fileName+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, r := range retained {
+		if err := enc.Encode(r); err != nil {
+			_ = tmp.Close()
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return 0, err
+	}
+	return len(all) - len(retained), nil
+}
+
+// readLocked reads all recorded results, in the order they were appended.
+// Lines that fail to decode (e.g. partially written ones, if we crashed
+// mid-append) are skipped.
+func (s *Store) readLocked() ([]probe.Result, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	// Read line by line without a limit on line length, as results with many
+	// jobs or diagnostics can get large.
+	var results []probe.Result
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var r probe.Result
+			if err := json.Unmarshal(line, &r); err == nil {
+				results = append(results, r)
+			}
+		}
+		if err == io.EOF {
+			return results, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package history_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/fiotest"
+	"github.com/irfansharif/probe/history"
+)
+
+func result(kind probe.Kind, value uint64, start time.Time) probe.Result {
+	return probe.Result{
+		Kind:   kind,
+		Value:  value,
+		Device: probe.Device{Major: 259, Minor: 1, Name: "nvme0n1p1", Partition: 1, Serial: "S4EVNX0N"},
+		Config: probe.Config{IOEngine: probe.LibAIO, BlockSize: 4 << 10, Jobs: 1, IODepth: 64},
+		Start:  start,
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := history.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	old := result(probe.WriteIOPS, 1000, now.Add(-48*time.Hour))
+	recent := result(probe.WriteIOPS, 2000, now.Add(-time.Hour))
+	incomplete := result(probe.WriteIOPS, 10, now.Add(-time.Minute))
+	incomplete.Incomplete = true
+	other := result(probe.ReadIOPS, 3000, now)
+	for _, r := range []probe.Result{old, recent, incomplete, other} {
+		if err := store.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	key := history.KeyOf(old)
+	if exp := `serial:S4EVNX0N/part:1/write_iops/v1:{"block_size":4096,"iodepth":64,"ioengine":"libaio","jobs":1}`; key.String() != exp {
+		t.Errorf("key = %s, expected %s", key, exp)
+	}
+	results, err := store.List(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	latest, ok, err := store.Latest(key, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || latest.Value != 2000 {
+		t.Errorf("expected latest complete result (2000), got %+v (ok = %t)", latest, ok)
+	}
+	if _, ok, _ := store.Latest(key, time.Second); ok {
+		t.Errorf("expected no result newer than a second")
+	}
+
+	keys, err := store.Keys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Errorf("expected 2 keys, got %v", keys)
+	}
+
+	// Partially written lines (e.g. from crashing mid-append) are skipped.
+	f, err := os.OpenFile(filepath.Join(dir, "results.jsonl"), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("{\"kind\": \"write_io"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Append(result(probe.ReadIOPS, 4000, now)); err != nil {
+		t.Fatal(err)
+	}
+	if latest, _, err := store.Latest(history.KeyOf(other), 0); err != nil || latest.Value != 4000 {
+		t.Errorf("expected result appended after partial line, got %+v (err = %v)", latest, err)
+	}
+
+	removed, err := store.Expire(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 expired result, got %d", removed)
+	}
+	if results, err = store.List(key); err != nil {
+		t.Fatal(err)
+	} else if len(results) != 2 || results[0].Value != 2000 {
+		t.Errorf("unexpected results after expiry: %+v", results)
+	}
+
+	// Results survive reopening the store.
+	reopened, err := history.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := reopened.Latest(history.KeyOf(other), 0); err != nil || !ok {
+		t.Errorf("expected result after reopening, got ok = %t, err = %v", ok, err)
+	}
+}
+
+func TestDefaultWriteKey(t *testing.T) {
+	// Write probes write incompressible data by default, which results from
+	// before that was configurable don't record. Both should key the same.
+	res, err := probe.Run(context.Background(),
+		probe.WithKind(probe.WriteIOPS),
+		probe.WithIOEngine(probe.LibAIO),
+		probe.WithDirectory(filepath.Join(t.TempDir(), "dir")),
+		probe.WithDuration(10*time.Second),
+		probe.WithSize(16<<20),
+		probe.WithReservedSpace(0),
+		probe.WithRunner(&fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{WriteIOPS: 1000})}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Config.Data == (probe.DataPattern{}) {
+		t.Fatalf("expected the default data pattern to be recorded")
+	}
+	if exp := `v1:{"block_size":4096,"iodepth":64,"ioengine":"libaio","jobs":1}`; history.KeyOf(res).Config != exp {
+		t.Errorf("key = %s, expected %s", history.KeyOf(res).Config, exp)
+	}
+	legacy := res
+	legacy.Config.Data = probe.DataPattern{}
+	if history.KeyOf(legacy) != history.KeyOf(res) {
+		t.Errorf("legacy key = %s, expected %s", history.KeyOf(legacy), history.KeyOf(res))
+	}
+}
+
+func TestKeyGolden(t *testing.T) {
+	// Keys are what results are looked up by, so changing them orphans
+	// stored history. If this fails, either keep existing keys as they are,
+	// or bump the key version and say so.
+	percent := 80
+	for _, tc := range []struct {
+		config probe.Config
+		exp    string
+	}{
+		{
+			probe.Config{IOEngine: probe.LibAIO, BlockSize: 4 << 10, Jobs: 1, IODepth: 64, Size: 1 << 30, Duration: time.Minute},
+			`v1:{"block_size":4096,"iodepth":64,"ioengine":"libaio","jobs":1}`,
+		},
+		{
+			probe.Config{
+				IOEngine: probe.IOUring, BlockSize: 1 << 20, Jobs: 8, IODepth: 32,
+				ReadWrite: probe.RandReadWrite, ReadMix: 70, MaxRate: 100 << 20,
+				RandomDistribution: probe.Zipf(1.2), PercentageRandom: &percent,
+				Buffered: true, Fsync: 16,
+				Data:       probe.DataPattern{CompressPercentage: 50},
+				IOPriority: probe.IOPriority{Class: probe.BestEffortClass, Level: 4},
+				ExtraArgs:  []string{"--norandommap"},
+			},
+			`v1:{"block_size":1048576,"buffered":true,"data":{"compress_percentage":50},` +
+				`"extra_args":["--norandommap"],"fsync":16,"io_priority":{"class":2,"level":4},` +
+				`"iodepth":32,"ioengine":"io_uring","jobs":8,"max_rate":104857600,"percentage_random":80,` +
+				`"random_distribution":"zipf:1.2","rw":"randrw","rwmixread":70}`,
+		},
+	} {
+		if got := tc.config.Key(); got != tc.exp {
+			t.Errorf("key = %s, expected %s", got, tc.exp)
+		}
+	}
+}
+
+func TestStoreLargeResults(t *testing.T) {
+	store, err := history.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := result(probe.WriteIOPS, 1000, time.Now())
+	for i := 0; i < 1<<14; i++ {
+		large.Diagnostics = append(large.Diagnostics, probe.Diagnostic{
+			Message: fmt.Sprintf("fio: diagnostic %d, padded out to make for a result line over 1MiB", i),
+		})
+	}
+	for _, r := range []probe.Result{large, result(probe.ReadIOPS, 2000, time.Now())} {
+		if err := store.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, r := range []probe.Result{large, result(probe.ReadIOPS, 0, time.Time{})} {
+		if latest, ok, err := store.Latest(history.KeyOf(r), 0); err != nil || !ok {
+			t.Errorf("expected %s result, got ok = %t, err = %v", r.Kind, ok, err)
+		} else if r.Kind == probe.WriteIOPS && len(latest.Diagnostics) != len(large.Diagnostics) {
+			t.Errorf("expected %d diagnostics, got %d", len(large.Diagnostics), len(latest.Diagnostics))
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package history
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/irfansharif/probe"
+)
+
+// Metric is a dimension along which a device's results can degrade.
+type Metric string
+
+const (
+	// Throughput is the probe's measured value (bandwidth or IOPS); it
+	// degrades by dropping.
+	Throughput Metric = "throughput"
+	// TailLatency is the p99 completion latency; it degrades by rising.
+	TailLatency Metric = "p99_latency"
+)
+
+// TrendOptions configure degradation detection.
+type TrendOptions struct {
+	// Window is the number of most recent results compared against the ones
+	// before them. Defaults to 3.
+	Window int
+	// Baseline is the (maximum) number of results preceding the window that
+	// they're compared against; at least 3 are needed. Defaults to 10.
+	Baseline int
+	// MinChange is the minimum relative change in a metric's mean worth
+	// alerting on, e.g. 0.1 for a 10% drop in throughput. Defaults to 0.1.
+	MinChange float64
+	// MaxPValue is the significance level, i.e. the largest p-value (from
+	// Welch's t-test) at which a change is considered real. Defaults to 0.01.
+	MaxPValue float64
+}
+
+func (o *TrendOptions) setDefaults() {
+	if o.Window == 0 {
+		o.Window = 3
+	}
+	if o.Baseline == 0 {
+		o.Baseline = 10
+	}
+	if o.MinChange == 0 {
+		o.MinChange = 0.1
+	}
+	if o.MaxPValue == 0 {
+		o.MaxPValue = 0.01
+	}
+}
+
+// minBaseline is the fewest baseline results we're willing to compare against.
+const minBaseline = 3
+
+// Alert describes a statistically significant degradation in one of a
+// device's metrics.
+type Alert struct {
+	Key Key `json:"key"`
+	// Config describes the configuration the results were measured with;
+	// see probe.Config.String.
+	Config string `json:"config"`
+	Metric Metric `json:"metric"`
+	// Baseline and Recent are the metric's means before and within the
+	// window, in the metric's units (bytes/s, IOPS, or nanoseconds).
+	Baseline float64 `json:"baseline"`
+	Recent   float64 `json:"recent"`
+	// Change is the relative change in the mean, e.g. -0.25 for a 25% drop.
+	Change float64 `json:"change"`
+	// PValue is the probability of seeing a difference this large if there
+	// were none.
+	PValue float64 `json:"p_value"`
+	// Samples is the number of (baseline, recent) results compared.
+	Samples [2]int `json:"samples"`
+	// Since is when the first result in the window was measured.
+	Since time.Time `json:"since"`
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("%s/%s/%s: %s changed by %+.1f%% since %s (%.4g -> %.4g, p=%.2g)",
+		a.Key.Device, a.Key.Kind, a.Config, a.Metric, a.Change*100, a.Since.Format(time.RFC3339),
+		a.Baseline, a.Recent, a.PValue)
+}
+
+// Degradations analyzes the given results for statistically significant drops
+// in throughput or rises in tail latency, comparing the most recent results
+// for each key against the ones preceding them. Incomplete results are
+// ignored, as are ones reporting a cgroup limit rather than a measurement
+// (see probe.Result.FromCgroup), lest changing the limit look like a
+// degradation. It returns no alerts for keys without enough history.
+func Degradations(results []probe.Result, opts TrendOptions) []Alert {
+	opts.setDefaults()
+
+	byKey := make(map[Key][]probe.Result)
+	var keys []Key
+	for _, r := range results {
+		if r.Incomplete || r.FromCgroup {
+			continue
+		}
+		key := KeyOf(r)
+		if _, ok := byKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[key] = append(byKey[key], r)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	var alerts []Alert
+	for _, key := range keys {
+		rs := byKey[key]
+		sort.SliceStable(rs, func(i, j int) bool {
+			return rs[i].Start.Before(rs[j].Start)
+		})
+		if len(rs) < opts.Window+minBaseline {
+			continue
+		}
+		recent := rs[len(rs)-opts.Window:]
+		baseline := rs[:len(rs)-opts.Window]
+		if len(baseline) > opts.Baseline {
+			baseline = baseline[len(baseline)-opts.Baseline:]
+		}
+
+		for _, m := range []struct {
+			metric   Metric
+			value    func(probe.Result) float64
+			degraded func(change float64) bool
+		}{
+			{
+				metric:   Throughput,
+				value:    func(r probe.Result) float64 { return float64(r.Value) },
+				degraded: func(change float64) bool { return change <= -opts.MinChange },
+			},
+			{
+				metric:   TailLatency,
+				value:    func(r probe.Result) float64 { return float64(r.Latency.P99) },
+				degraded: func(change float64) bool { return change >= opts.MinChange },
+			},
+		} {
+			b, r := values(baseline, m.value), values(recent, m.value)
+			bmean, rmean := mean(b), mean(r)
+			if bmean == 0 {
+				continue // not recorded, e.g. latencies from older results
+			}
+			change := (rmean - bmean) / bmean
+			if !m.degraded(change) {
+				continue
+			}
+			p := welchPValue(b, r)
+			if p > opts.MaxPValue {
+				continue
+			}
+			alerts = append(alerts, Alert{
+				Key:      key,
+				Config:   recent[len(recent)-1].Config.String(),
+				Metric:   m.metric,
+				Baseline: bmean,
+				Recent:   rmean,
+				Change:   change,
+				PValue:   p,
+				Samples:  [2]int{len(b), len(r)},
+				Since:    recent[0].Start,
+			})
+		}
+	}
+	return alerts
+}
+
+// Degradations analyzes all recorded results; see Degradations.
+func (s *Store) Degradations(opts TrendOptions) ([]Alert, error) {
+	s.mu.Lock()
+	all, err := s.readLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return Degradations(all, opts), nil
+}
+
+func values(results []probe.Result, f func(probe.Result) float64) []float64 {
+	vs := make([]float64, len(results))
+	for i, r := range results {
+		vs[i] = f(r)
+	}
+	return vs
+}
+
+func mean(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// variance returns the unbiased sample variance.
+func variance(xs []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	m := mean(xs)
+	var ss float64
+	for _, x := range xs {
+		ss += (x - m) * (x - m)
+	}
+	return ss / float64(len(xs)-1)
+}
+
+// welchPValue returns the two-sided p-value of Welch's t-test for a
+// difference in the means of the two samples.
+func welchPValue(a, b []float64) float64 {
+	na, nb := float64(len(a)), float64(len(b))
+	va, vb := variance(a)/na, variance(b)/nb
+	if va+vb == 0 {
+		// No variance in either sample; any difference in means is
+		// definitive.
+		if mean(a) == mean(b) {
+			return 1
+		}
+		return 0
+	}
+	t := (mean(a) - mean(b)) / math.Sqrt(va+vb)
+	// Welch–Satterthwaite degrees of freedom.
+	df := (va + vb) * (va + vb) / (va*va/(na-1) + vb*vb/(nb-1))
+	if math.IsNaN(df) || math.IsInf(df, 0) {
+		df = na + nb - 2
+	}
+	// For Student's t distribution, P(|T| > |t|) = I_{df/(df+t²)}(df/2, 1/2).
+	return regIncBeta(df/2, 0.5, df/(df+t*t))
+}
+
+// regIncBeta returns the regularized incomplete beta function I_x(a, b),
+// evaluated using its continued fraction representation (see Numerical
+// Recipes, §6.4).
+func regIncBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	lga, _ := math.Lgamma(a)
+	lgb, _ := math.Lgamma(b)
+	lgab, _ := math.Lgamma(a + b)
+	front := math.Exp(lgab - lga - lgb + a*math.Log(x) + b*math.Log(1-x))
+	if x < (a+1)/(a+b+2) {
+		return front * betaCF(a, b, x) / a
+	}
+	return 1 - front*betaCF(b, a, 1-x)/b
+}
+
+func betaCF(a, b, x float64) float64 {
+	const maxIterations, epsilon, tiny = 200, 1e-12, 1e-300
+	qab, qap, qam := a+b, a+1, a-1
+	c, d := 1.0, 1-qab*x/qap
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	h := d
+	for i := 1; i <= maxIterations; i++ {
+		m, m2 := float64(i), float64(2*i)
+		aa := m * (b - m) * x / ((qam + m2) * (a + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		h *= d * c
+		aa = -(a + m) * (qab + m) * x / ((a + m2) * (qap + m2))
+		d = 1 + aa*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + aa/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,132 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package history
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe"
+)
+
+func TestDegradations(t *testing.T) {
+	start := time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)
+	series := func(values []uint64, p99s []time.Duration) []probe.Result {
+		var rs []probe.Result
+		for i := range values {
+			rs = append(rs, probe.Result{
+				Kind:    probe.WriteBandwidth,
+				Value:   values[i],
+				Latency: probe.Latency{P99: p99s[i]},
+				Device:  probe.Device{Major: 259, Minor: 0, Name: "nvme0n1"},
+				Config:  probe.Config{IOEngine: probe.LibAIO, BlockSize: 1 << 20, Jobs: 8, IODepth: 64},
+				Start:   start.Add(time.Duration(i) * 24 * time.Hour),
+			})
+		}
+		return rs
+	}
+	ms := func(vs ...int) []time.Duration {
+		var ds []time.Duration
+		for _, v := range vs {
+			ds = append(ds, time.Duration(v)*time.Millisecond)
+		}
+		return ds
+	}
+
+	t.Run("stable", func(t *testing.T) {
+		rs := series(
+			[]uint64{1000, 1010, 990, 1005, 995, 1002, 998, 1001},
+			ms(10, 11, 10, 9, 10, 11, 10, 10),
+		)
+		if alerts := Degradations(rs, TrendOptions{}); len(alerts) != 0 {
+			t.Errorf("expected no alerts, got %v", alerts)
+		}
+	})
+
+	t.Run("throughput-drop", func(t *testing.T) {
+		rs := series(
+			[]uint64{1000, 1010, 990, 1005, 995, 702, 698, 701},
+			ms(10, 11, 10, 9, 10, 11, 10, 10),
+		)
+		alerts := Degradations(rs, TrendOptions{})
+		if len(alerts) != 1 {
+			t.Fatalf("expected 1 alert, got %v", alerts)
+		}
+		a := alerts[0]
+		if a.Metric != Throughput || math.Abs(a.Change+0.3) > 0.01 || a.Samples != [2]int{5, 3} || !a.Since.Equal(rs[5].Start) {
+			t.Errorf("unexpected alert: %s", a)
+		}
+	})
+
+	t.Run("latency-rise", func(t *testing.T) {
+		rs := series(
+			[]uint64{1000, 1010, 990, 1005, 995, 1002, 998, 1001},
+			ms(10, 11, 10, 9, 10, 20, 21, 19),
+		)
+		alerts := Degradations(rs, TrendOptions{})
+		if len(alerts) != 1 || alerts[0].Metric != TailLatency {
+			t.Fatalf("expected 1 tail latency alert, got %v", alerts)
+		}
+	})
+
+	t.Run("noisy", func(t *testing.T) {
+		// A drop that's within the noise isn't significant.
+		rs := series(
+			[]uint64{1000, 600, 1400, 700, 1300, 800, 900, 850},
+			ms(10, 11, 10, 9, 10, 11, 10, 10),
+		)
+		if alerts := Degradations(rs, TrendOptions{}); len(alerts) != 0 {
+			t.Errorf("expected no alerts, got %v", alerts)
+		}
+	})
+
+	t.Run("cgroup-limit", func(t *testing.T) {
+		// Lowering the cgroup limit isn't a degradation of the device.
+		rs := series(
+			[]uint64{1000, 1010, 990, 1005, 995, 1002, 998, 1001},
+			ms(10, 11, 10, 9, 10, 11, 10, 10),
+		)
+		for _, v := range []uint64{500, 500, 500} {
+			rs = append(rs, probe.Result{
+				Kind: probe.WriteBandwidth, Value: v, FromCgroup: true,
+				Device: rs[0].Device, Config: rs[0].Config,
+				Start: rs[len(rs)-1].Start.Add(24 * time.Hour),
+			})
+		}
+		if alerts := Degradations(rs, TrendOptions{}); len(alerts) != 0 {
+			t.Errorf("expected no alerts, got %v", alerts)
+		}
+	})
+
+	t.Run("insufficient-history", func(t *testing.T) {
+		rs := series([]uint64{1000, 1000, 500, 500}, ms(10, 10, 10, 10))
+		if alerts := Degradations(rs, TrendOptions{}); len(alerts) != 0 {
+			t.Errorf("expected no alerts, got %v", alerts)
+		}
+	})
+}
+
+func TestWelchPValue(t *testing.T) {
+	// Welch's t-test example from Wikipedia: t = -2.46, df = 24.99.
+	a := []float64{27.5, 21.0, 19.0, 23.6, 17.0, 17.9, 16.9, 20.1, 21.9, 22.6, 23.1, 19.6, 19.0, 21.7, 21.4}
+	b := []float64{27.1, 22.0, 20.8, 23.4, 23.4, 23.5, 25.8, 22.0, 24.8, 20.2, 21.9, 22.1, 22.9, 20.5, 24.4}
+	if p := welchPValue(a, b); math.Abs(p-0.02138) > 0.00001 {
+		t.Errorf("p = %v, expected ~0.02138", p)
+	}
+	if p := welchPValue(a, a); math.Abs(p-1) > 1e-9 {
+		t.Errorf("p = %v, expected 1", p)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/irfansharif/probe/internal"
+)
+
+// defaultForegroundIOPS is the foreground job's rate in interference probes,
+// unless configured otherwise.
+const defaultForegroundIOPS = 1000
+
+// InterferenceConfig configures an interference probe.
+type InterferenceConfig struct {
+	// ForegroundIOPS is the rate of the foreground job's latency-sensitive
+	// I/O: 4KiB random reads, issued one at a time (i.e. at an I/O depth of
+	// 1). It defaults to 1000.
+	ForegroundIOPS uint64
+	// BackgroundRates are the rates (in bytes/s) the background job's
+	// sequential writes are stepped through. A zero rate runs the foreground
+	// job alone, as a baseline.
+	BackgroundRates []uint64
+}
+
+// InterferenceStep is what was measured at a single background rate.
+type InterferenceStep struct {
+	// BackgroundRate is the background job's configured rate.
+	BackgroundRate uint64 `json:"background_rate"`
+	// BackgroundBandwidth is the write bandwidth the background job achieved,
+	// which falls short of its rate if the device is saturated.
+	BackgroundBandwidth uint64 `json:"background_bandwidth"`
+	// ForegroundIOPS is the read IOPS the foreground job achieved.
+	ForegroundIOPS uint64 `json:"foreground_iops"`
+	// Foreground is the completion latency of the foreground job's reads.
+	Foreground Latency `json:"foreground"`
+}
+
+// InterferenceResult is the outcome of an interference probe.
+type InterferenceResult struct {
+	// Steps are what was measured at each background rate, in the order
+	// configured.
+	Steps []InterferenceStep `json:"steps"`
+	// Device is the block device backing the probe directory.
+	Device Device `json:"device"`
+	// Config is what the foreground job was configured with.
+	Config Config `json:"config"`
+	// Start is when the probe started.
+	Start time.Time `json:"start"`
+	// Elapsed is the wall time the probe took, across all steps.
+	Elapsed time.Duration `json:"elapsed"`
+	// Incomplete is set if the probe was cut short (i.e. the context was
+	// cancelled, or fio failed), in which case the last step reflects only
+	// what was measured until then, and later steps are missing.
+	Incomplete bool `json:"incomplete,omitempty"`
+	// FioVersion is the version of fio that ran the probe.
+	FioVersion string `json:"fio_version"`
+	// Diagnostics are non-fatal messages fio emitted during the probe.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// Interference measures how a latency-sensitive foreground workload degrades
+// under background load, i.e. how much background write bandwidth can be
+// allowed before foreground reads suffer. For each of the configured
+// background rates, fio runs a fixed-rate foreground job of random reads
+// concurrently with a background job of sequential writes, reporting the
+// foreground's latency as a function of the background's rate.
+//
+// It's configured using the same options as Run, which apply to each step.
+// Those shaping the measured workload (WithKind, WithMaxRate, WithReadWrite,
+// WithReadMix, WithBlockSize, WithBlockSizeSplit, WithIODepth, WithJobs,
+// WithRandomDistribution, WithPercentageRandom and WithFsync) are rejected,
+// as are WithArtifacts and WithCgroupFastPath. WithIOPriority and
+// WithDataPattern apply only to the background job, the former to measure how
+// well it isolates the foreground, and WithExtraArgs applies to both jobs.
+func Interference(ctx context.Context, cfg InterferenceConfig, opts ...Option) (_ InterferenceResult, err error) {
+	if len(cfg.BackgroundRates) == 0 {
+		return InterferenceResult{}, fmt.Errorf("no background rates to step through")
+	}
+	if cfg.ForegroundIOPS == 0 {
+		cfg.ForegroundIOPS = defaultForegroundIOPS
+	}
+	o, err := configure(ctx, len(cfg.BackgroundRates), opts)
+	if err != nil {
+		return InterferenceResult{}, err
+	}
+	if err := o.validateInterference(); err != nil {
+		return InterferenceResult{}, err
+	}
+
+	if err := resetDirectory(o.Directory); err != nil {
+		return InterferenceResult{}, err
+	}
+	defer func() {
+		if err2 := os.RemoveAll(o.Directory); err2 != nil {
+			if err == nil {
+				err = err2
+			} else {
+				err = fmt.Errorf("%s: %s", err2, err)
+			}
+		}
+	}()
+
+	device, _ := o.device()
+	// The foreground and background jobs each lay out a file, which are
+	// reused across steps.
+	plan, err := planSpace(o, 2, 1<<20 /* 1MiB */)
+	if err != nil {
+		return InterferenceResult{}, err
+	}
+
+	res := InterferenceResult{
+		Device: device,
+		Config: Config{
+			IOEngine:  o.IOEngine,
+			BlockSize: 4 << 10, // 4KiB
+			Jobs:      1,
+			IODepth:   1,
+			MaxRate:   cfg.ForegroundIOPS,
+			Size:      plan.Footprint(),
+			Duration:  o.Duration,
+		},
+		Start: time.Now(),
+	}
+	for _, rate := range cfg.BackgroundRates {
+		// Without group reporting, fio reports each job separately.
+		jobs := []*Job{o.newJob("foreground", plan).
+			ReadWrite(RandRead).
+			BlockSize(4 << 10). // 4KiB
+			IODepth(1).
+			RateIOPS(cfg.ForegroundIOPS).
+			Extra(o.ExtraArgs...),
+		}
+		if rate != 0 {
+			data, _ := o.data(SeqWrite)
+			jobs = append(jobs, o.newJob("background", plan).
+				ReadWrite(SeqWrite).
+				BlockSize(1<<20). // 1MiB
+				IODepth(ioDepth).
+				Rate(rate).
+				DataPattern(data).
+				IOPriority(o.IOPriority).
+				Extra(o.ExtraArgs...),
+			)
+		}
+		args, err := commandLine(jobs...)
+		if err != nil {
+			return InterferenceResult{}, err
+		}
+
+		run, err := o.execute(ctx, args)
+		if err != nil {
+			// Keep what earlier steps measured.
+			res.Elapsed = time.Since(res.Start)
+			res.Incomplete = true
+			return res, err
+		}
+		res.FioVersion = run.Output.FioVersion
+		res.Diagnostics = append(res.Diagnostics, run.Diagnostics...)
+
+		foreground := internal.Aggregate(run.Output.JobsNamed("foreground"))
+		background := internal.Aggregate(run.Output.JobsNamed("background"))
+		res.Steps = append(res.Steps, InterferenceStep{
+			BackgroundRate:      rate,
+			BackgroundBandwidth: uint64(background.Write.BWBytes),
+			ForegroundIOPS:      uint64(foreground.Read.IOPS),
+			Foreground:          latencyOf(foreground.Read),
+		})
+		if run.Interrupted {
+			res.Incomplete = true
+			break
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	res.Elapsed = time.Since(res.Start)
+	// Cancellation only cuts the probe short if it interrupted a step, or
+	// left steps to go; not if it came after fio finished the last one.
+	if len(res.Steps) < len(cfg.BackgroundRates) {
+		res.Incomplete = true
+	}
+	if res.Incomplete {
+		return res, ctx.Err()
+	}
+	return res, nil
+}
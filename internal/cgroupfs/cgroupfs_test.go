@@ -0,0 +1,83 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cgroupfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIOMax(t *testing.T) {
+	m, err := ParseIOMax([]byte("8:16 rbps=2097152 wbps=max riops=max wiops=120\n8:0 rbps=max wbps=max riops=max wiops=max\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (Limits{RBPS: 2097152, WIOPS: 120}); m["8:16"] != exp {
+		t.Errorf("8:16 = %+v, expected %+v", m["8:16"], exp)
+	}
+	if got, exp := FormatIOMax("8:16", m["8:16"]), "8:16 rbps=2097152 wbps=max riops=max wiops=120"; got != exp {
+		t.Errorf("formatted %q, expected %q", got, exp)
+	}
+	if _, err := ParseIOMax([]byte("8:16 rbps=lots")); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestEffective(t *testing.T) {
+	tmp := t.TempDir()
+	write := func(path, data string) {
+		path = filepath.Join(tmp, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A hybrid setup, with blkio mounted as cgroup v1 and nothing in the
+	// unified hierarchy. The v1 hierarchy is mounted from a (namespaced) root,
+	// so the process's cgroup is relative to it.
+	write("proc/mountinfo", fmt.Sprintf(`24 1 0:22 / / rw - ext4 /dev/sda1 rw
+39 32 0:35 /kubepods %s/blkio rw,relatime - cgroup cgroup rw,blkio
+40 32 0:36 / %s/pids rw,relatime - cgroup cgroup rw,pids
+42 32 0:38 / %s/unified rw,relatime - cgroup2 cgroup2 rw
+`, tmp, tmp, tmp))
+	write("proc/cgroup", "8:pids:/kubepods/pod\n7:blkio:/kubepods/pod\n0::/\n")
+	write("blkio/blkio.throttle.read_bps_device", "8:0 1000\n8:16 5\n")
+	write("blkio/pod/blkio.throttle.read_bps_device", "8:0 2000\n")
+	write("blkio/pod/blkio.throttle.write_iops_device", "8:0 300\n")
+	write("pids/kubepods/pod/blkio.throttle.read_bps_device", "8:0 1\n") // not blkio
+	write("unified/io.max", "8:0 rbps=max wbps=max riops=max wiops=max\n")
+
+	l, err := Effective(filepath.Join(tmp, "proc"), "8:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (Limits{RBPS: 1000, WIOPS: 300}); l != exp {
+		t.Errorf("limits = %+v, expected %+v", l, exp)
+	}
+	if l, err := Effective(filepath.Join(tmp, "proc"), "8:32"); err != nil || l != (Limits{}) {
+		t.Errorf("limits = %+v (err = %v), expected none", l, err)
+	}
+
+	// Move into the unified hierarchy, with a limit there too.
+	write("proc/cgroup", "0::/workload\n")
+	write("unified/workload/io.max", "8:0 rbps=500 wbps=max riops=max wiops=max\n")
+	if l, err := Effective(filepath.Join(tmp, "proc"), "8:0"); err != nil || l != (Limits{RBPS: 500}) {
+		t.Errorf("limits = %+v (err = %v), expected rbps=500", l, err)
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cgroupfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Min returns the stricter of the two limits, for each dimension.
+func (l Limits) Min(o Limits) Limits {
+	min := func(a, b uint64) uint64 {
+		if a == 0 || (b != 0 && b < a) {
+			return b
+		}
+		return a
+	}
+	return Limits{
+		RBPS:  min(l.RBPS, o.RBPS),
+		WBPS:  min(l.WBPS, o.WBPS),
+		RIOPS: min(l.RIOPS, o.RIOPS),
+		WIOPS: min(l.WIOPS, o.WIOPS),
+	}
+}
+
+// Effective returns the I/O limits in effect for the given (whole disk)
+// device, for the process whose /proc/<pid> directory is at proc (e.g.
+// /proc/self). Limits are hierarchical, so these are the strictest ones
+// configured across the process's cgroup and its ancestors, considering both
+// cgroup v2 (io.max) and cgroup v1 (blkio.throttle.*) hierarchies.
+func Effective(proc, majmin string) (Limits, error) {
+	mountinfo, err := os.ReadFile(filepath.Join(proc, "mountinfo"))
+	if err != nil {
+		return Limits{}, err
+	}
+	cgroups, err := os.ReadFile(filepath.Join(proc, "cgroup"))
+	if err != nil {
+		return Limits{}, err
+	}
+	mounts, err := parseMountinfo(mountinfo)
+	if err != nil {
+		return Limits{}, err
+	}
+	membership := parseProcCgroup(cgroups)
+
+	var l Limits
+	for _, m := range mounts {
+		var read func(dir string) (IOMax, error)
+		var path string
+		var ok bool
+		switch {
+		case m.fstype == "cgroup2":
+			path, ok = membership[""]
+			read = readIOMax
+		case m.fstype == "cgroup" && m.hasOption("blkio"):
+			path, ok = membership["blkio"]
+			read = readThrottle
+		}
+		if !ok {
+			continue
+		}
+		dir := m.point
+		if rel, err := filepath.Rel(m.root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			dir = filepath.Join(m.point, rel)
+		}
+		for {
+			limits, err := read(dir)
+			if err != nil {
+				return Limits{}, err
+			}
+			l = l.Min(limits[majmin])
+			if dir == m.point || dir == filepath.Dir(dir) {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+	}
+	return l, nil
+}
+
+// WholeDisk returns MAJ:MIN for the disk the given partition is on, using the
+// sysfs block device directory (i.e. /sys/dev/block). I/O limits apply to
+// whole disks only.
+func WholeDisk(sysfsBlock, majmin string) (string, error) {
+	// /sys/dev/block/MAJ:MIN links to the partition, nested under its disk.
+	partition, err := filepath.EvalSymlinks(filepath.Join(sysfsBlock, majmin))
+	if err != nil {
+		return "", fmt.Errorf("resolving disk for partition %s: %w", majmin, err)
+	}
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(partition), "dev"))
+	if err != nil {
+		return "", fmt.Errorf("resolving disk for partition %s: %w", majmin, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ParseThrottle parses the contents of a cgroup v1 blkio.throttle.* file
+// (e.g. blkio.throttle.read_bps_device), which looks as follows:
+//
+//	8:16 2097152
+func ParseThrottle(data []byte) (map[string]uint64, error) {
+	m := make(map[string]uint64)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid throttle entry: %q", scanner.Text())
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid throttle entry: %q", scanner.Text())
+		}
+		m[fields[0]] = v
+	}
+	return m, scanner.Err()
+}
+
+// readIOMax reads the io.max file in the given cgroup v2 directory. The root
+// cgroup has none, which isn't an error.
+func readIOMax(dir string) (IOMax, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "io.max"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return ParseIOMax(data)
+}
+
+// readThrottle reads the blkio.throttle.* files in the given cgroup v1
+// directory.
+func readThrottle(dir string) (IOMax, error) {
+	m := make(IOMax)
+	for _, f := range []struct {
+		name string
+		set  func(*Limits, uint64)
+	}{
+		{"blkio.throttle.read_bps_device", func(l *Limits, v uint64) { l.RBPS = v }},
+		{"blkio.throttle.write_bps_device", func(l *Limits, v uint64) { l.WBPS = v }},
+		{"blkio.throttle.read_iops_device", func(l *Limits, v uint64) { l.RIOPS = v }},
+		{"blkio.throttle.write_iops_device", func(l *Limits, v uint64) { l.WIOPS = v }},
+	} {
+		data, err := os.ReadFile(filepath.Join(dir, f.name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		throttles, err := ParseThrottle(data)
+		if err != nil {
+			return nil, err
+		}
+		for majmin, v := range throttles {
+			l := m[majmin]
+			f.set(&l, v)
+			m[majmin] = l
+		}
+	}
+	return m, nil
+}
+
+type mount struct {
+	root, point, fstype string
+	options             []string
+}
+
+func (m mount) hasOption(opt string) bool {
+	for _, o := range m.options {
+		if o == opt {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMountinfo parses /proc/<pid>/mountinfo, returning cgroup mounts. Lines
+// look as follows, with the fields after the separator being the filesystem
+// type, source, and super block options:
+//
+//	39 32 0:35 / /sys/fs/cgroup/blkio rw,relatime - cgroup cgroup rw,blkio
+func parseMountinfo(data []byte) ([]mount, error) {
+	var mounts []mount
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i := 6; i < len(fields); i++ {
+			if fields[i] == "-" {
+				sep = i
+				break
+			}
+		}
+		if len(fields) < 5 || sep < 0 || len(fields) < sep+4 {
+			return nil, fmt.Errorf("invalid mountinfo entry: %q", scanner.Text())
+		}
+		fstype := fields[sep+1]
+		if fstype != "cgroup" && fstype != "cgroup2" {
+			continue
+		}
+		mounts = append(mounts, mount{
+			root:    unescape(fields[3]),
+			point:   unescape(fields[4]),
+			fstype:  fstype,
+			options: strings.Split(fields[sep+3], ","),
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// unescape undoes the octal escaping of whitespace and backslashes in
+// mountinfo paths.
+func unescape(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n", `\134`, `\`).Replace(s)
+}
+
+// parseProcCgroup parses /proc/<pid>/cgroup, returning the process's cgroup
+// path in each hierarchy, keyed by controller. The cgroup v2 path is keyed by
+// the empty string. Lines look as follows:
+//
+//	7:blkio:/system.slice
+//	0::/system.slice/workload.service
+func parseProcCgroup(data []byte) map[string]string {
+	m := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			m[controller] = parts[2]
+		}
+	}
+	return m
+}
@@ -0,0 +1,113 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package cgroupfs reads and writes cgroup I/O controller files.
+package cgroupfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits are per-device I/O limits. Zero values are unlimited.
+type Limits struct {
+	RBPS, WBPS, RIOPS, WIOPS uint64
+}
+
+// IOMax is the parsed contents of a cgroup v2 io.max file, keyed by MAJ:MIN.
+type IOMax map[string]Limits
+
+// ParseIOMax parses the contents of a cgroup v2 io.max file, which looks as
+// follows:
+//
+//	8:16 rbps=2097152 wbps=max riops=max wiops=120
+func ParseIOMax(data []byte) (IOMax, error) {
+	m := make(IOMax)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		var l Limits
+		for _, field := range fields[1:] {
+			key, value, ok := strings.Cut(field, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid io.max entry: %q", scanner.Text())
+			}
+			var v uint64
+			if value != "max" {
+				var err error
+				if v, err = strconv.ParseUint(value, 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid io.max entry: %q", scanner.Text())
+				}
+			}
+			switch key {
+			case "rbps":
+				l.RBPS = v
+			case "wbps":
+				l.WBPS = v
+			case "riops":
+				l.RIOPS = v
+			case "wiops":
+				l.WIOPS = v
+			}
+		}
+		m[fields[0]] = l
+	}
+	return m, scanner.Err()
+}
+
+// Keys are the limits in an io.max entry.
+var Keys = []string{"rbps", "wbps", "riops", "wiops"}
+
+// FormatIOMax formats an io.max entry setting all limits for the given
+// device, i.e. what's written to io.max to configure them.
+func FormatIOMax(majmin string, l Limits) string {
+	return FormatIOMaxKeys(majmin, l, Keys...)
+}
+
+// FormatIOMaxKeys formats an io.max entry setting only the given limits (e.g.
+// "rbps") for the given device; the kernel leaves the others as they are.
+func FormatIOMaxKeys(majmin string, l Limits, keys ...string) string {
+	s := majmin
+	for _, key := range keys {
+		v := l.Get(key)
+		if v == 0 {
+			s += " " + key + "=max"
+		} else {
+			s += " " + key + "=" + strconv.FormatUint(v, 10)
+		}
+	}
+	return s
+}
+
+// Get returns the limit with the given key, e.g. "rbps".
+func (l Limits) Get(key string) uint64 {
+	switch key {
+	case "rbps":
+		return l.RBPS
+	case "wbps":
+		return l.WBPS
+	case "riops":
+		return l.RIOPS
+	case "wiops":
+		return l.WIOPS
+	default:
+		return 0
+	}
+}
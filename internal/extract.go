@@ -0,0 +1,70 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ExtractJSON extracts fio's JSON document from its standard output. fio
+// occasionally prints notes (e.g. "note: both iodepth >= 1 and synchronous I/O
+// engine are selected, queue depth will be capped at 1") before or after the
+// document; these are returned as residue, one per non-empty line.
+func ExtractJSON(stdout []byte) (doc []byte, residue []string, _ error) {
+	for offset := 0; offset < len(stdout); {
+		i := bytes.IndexByte(stdout[offset:], '{')
+		if i < 0 {
+			break
+		}
+		start := offset + i
+		// The document starts at the beginning of a line.
+		if start > 0 && stdout[start-1] != '\n' {
+			offset = start + 1
+			continue
+		}
+
+		dec := json.NewDecoder(bytes.NewReader(stdout[start:]))
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			offset = start + 1
+			continue
+		}
+		end := start + int(dec.InputOffset())
+		residue = append(Lines(stdout[:start]), Lines(stdout[end:])...)
+		return raw, residue, nil
+	}
+	return nil, nil, fmt.Errorf("no JSON document found in fio output: %q", truncate(stdout, 256))
+}
+
+// Lines splits the given output into trimmed, non-empty lines.
+func Lines(output []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+func truncate(b []byte, n int) string {
+	if len(b) <= n {
+		return string(b)
+	}
+	return string(b[:n]) + "..."
+}
@@ -0,0 +1,71 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package internal
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	const doc = `{
+  "fio version" : "fio-3.30",
+  "jobs" : [ { "jobname" : "{braces}" } ]
+}`
+	for _, tc := range []struct {
+		name       string
+		stdout     string
+		expResidue []string
+	}{
+		{
+			name:   "clean",
+			stdout: doc + "\n",
+		},
+		{
+			name:       "leading-note",
+			stdout:     "note: both iodepth >= 1 and synchronous I/O engine are selected, queue depth will be capped at 1\n" + doc + "\n",
+			expResidue: []string{"note: both iodepth >= 1 and synchronous I/O engine are selected, queue depth will be capped at 1"},
+		},
+		{
+			name:       "trailing-warning",
+			stdout:     doc + "\nfio: file hash not empty on exit\n",
+			expResidue: []string{"fio: file hash not empty on exit"},
+		},
+		{
+			name:       "leading-brace-noise",
+			stdout:     "fio: {unexpected}\n{garbage\n" + doc,
+			expResidue: []string{"fio: {unexpected}", "{garbage"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, residue, err := ExtractJSON([]byte(tc.stdout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != doc {
+				t.Errorf("doc = %q, expected %q", got, doc)
+			}
+			if !reflect.DeepEqual(residue, tc.expResidue) {
+				t.Errorf("residue = %q, expected %q", residue, tc.expResidue)
+			}
+		})
+	}
+
+	if _, _, err := ExtractJSON([]byte("fio: ioengine libaio not loaded\n")); err == nil ||
+		!strings.Contains(err.Error(), "no JSON document") {
+		t.Errorf("expected 'no JSON document' error, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package internal
+
+import "math"
+
+// JobsNamed returns the jobs with the given name, in the order reported.
+// Jobs run with numjobs > 1 share their name, and without group reporting,
+// each is reported separately.
+func (o Output) JobsNamed(name string) []Job {
+	var jobs []Job
+	for _, j := range o.Jobs {
+		if j.JobName == name {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
+
+// Group returns the jobs in the given reporting group, in the order reported.
+// Jobs are grouped by new_group, and stonewalled jobs (i.e. later phases)
+// start new groups.
+func (o Output) Group(id int) []Job {
+	var jobs []Job
+	for _, j := range o.Jobs {
+		if j.GroupID == id {
+			jobs = append(jobs, j)
+		}
+	}
+	return jobs
+}
+
+// Groups returns the IDs of the reporting groups, in the order reported.
+func (o Output) Groups() []int {
+	var ids []int
+	seen := make(map[int]bool)
+	for _, j := range o.Jobs {
+		if !seen[j.GroupID] {
+			seen[j.GroupID] = true
+			ids = append(ids, j.GroupID)
+		}
+	}
+	return ids
+}
+
+// Aggregate combines the given (concurrently run) jobs' stats, as fio's
+// group reporting would: byte counts, bandwidth and IOPS are summed, and
+// latencies are combined across jobs. Only the fields documented on
+// aggregateRW and aggregateLatency are set. The aggregate takes the name and
+// group of the first job, and the longest elapsed time. A single job is
+// returned as is.
+func Aggregate(jobs []Job) Job {
+	switch len(jobs) {
+	case 0:
+		return Job{}
+	case 1:
+		return jobs[0]
+	}
+	agg := Job{
+		JobName: jobs[0].JobName,
+		GroupID: jobs[0].GroupID,
+		Options: jobs[0].Options,
+	}
+	reads := make([]ReadWriteStats, 0, len(jobs))
+	writes := make([]ReadWriteStats, 0, len(jobs))
+	for _, j := range jobs {
+		if j.Elapsed > agg.Elapsed {
+			agg.Elapsed = j.Elapsed
+		}
+		if agg.Error == 0 {
+			agg.Error = j.Error
+		}
+		reads = append(reads, j.Read)
+		writes = append(writes, j.Write)
+	}
+	agg.Read = aggregateRW(reads)
+	agg.Write = aggregateRW(writes)
+	return agg
+}
+
+// aggregateRW sums IOBytes, BWBytes, BW, IOPS and TotalIOs, takes the longest
+// Runtime, and combines ClatNS and LatNS.
+func aggregateRW(stats []ReadWriteStats) ReadWriteStats {
+	var agg ReadWriteStats
+	clat := make([]LatencyStats, 0, len(stats))
+	lat := make([]LatencyStats, 0, len(stats))
+	weights := make([]Number, 0, len(stats))
+	for _, s := range stats {
+		agg.IOBytes += s.IOBytes
+		agg.BWBytes += s.BWBytes
+		agg.BW += s.BW
+		agg.IOPS += s.IOPS
+		agg.TotalIOs += s.TotalIOs
+		if s.Runtime > agg.Runtime {
+			agg.Runtime = s.Runtime
+		}
+		clat = append(clat, s.ClatNS)
+		lat = append(lat, s.LatNS)
+		// Older versions don't report I/O counts; weigh by IOPS instead,
+		// which is proportional across concurrently run jobs.
+		w := s.TotalIOs
+		if w == 0 {
+			w = s.IOPS
+		}
+		weights = append(weights, w)
+	}
+	agg.ClatNS = aggregateLatency(clat, weights)
+	agg.LatNS = aggregateLatency(lat, weights)
+	return agg
+}
+
+// aggregateLatency combines latency stats, weighing each by the number of
+// I/Os it summarizes. The mean and standard deviation are exact (pooled), as
+// are the min and max. Percentiles can't be combined without the underlying
+// histograms, so the worst of each across inputs is used, which is an upper
+// bound.
+func aggregateLatency(stats []LatencyStats, weights []Number) LatencyStats {
+	var agg LatencyStats
+	var total, sum, sumSquares Number
+	for i, s := range stats {
+		w := weights[i]
+		if w == 0 {
+			continue
+		}
+		if agg.Min == 0 || (s.Min != 0 && s.Min < agg.Min) {
+			agg.Min = s.Min
+		}
+		if s.Max > agg.Max {
+			agg.Max = s.Max
+		}
+		total += w
+		sum += w * s.Mean
+		sumSquares += w * (s.Stddev*s.Stddev + s.Mean*s.Mean)
+		for k, v := range s.Percentiles {
+			if agg.Percentiles == nil {
+				agg.Percentiles = make(map[string]Number)
+			}
+			if v > agg.Percentiles[k] {
+				agg.Percentiles[k] = v
+			}
+		}
+	}
+	if total == 0 {
+		return agg
+	}
+	agg.Mean = sum / total
+	if variance := sumSquares/total - agg.Mean*agg.Mean; variance > 0 {
+		agg.Stddev = Number(math.Sqrt(float64(variance)))
+	}
+	return agg
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package internal
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestJobFields(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "fio-3.30.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := out.Jobs[0]
+	if job.JobName != "write_throughput" || job.GroupID != 0 || job.Elapsed != 13 || job.Error != 0 {
+		t.Errorf("unexpected job: %s (group %d, elapsed %v, error %v)", job.JobName, job.GroupID, job.Elapsed, job.Error)
+	}
+	if job.Options["rw"] != "write" || job.Options["numjobs"] != "8" {
+		t.Errorf("unexpected job options: %v", job.Options)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	// Two foreground readers and a background writer in the first group, and
+	// a stonewalled reader in the second.
+	out, err := Decode([]byte(`{
+  "fio version": "fio-3.35",
+  "jobs": [
+    {"jobname": "fg", "groupid": 0, "elapsed": 10,
+     "read": {"io_bytes": 100, "bw_bytes": 10, "iops": 30, "total_ios": 300,
+              "clat_ns": {"min": 5, "max": 50, "mean": 10, "stddev": 0, "percentile": {"99.000000": 40}}}},
+    {"jobname": "fg", "groupid": 0, "elapsed": 11,
+     "read": {"io_bytes": 200, "bw_bytes": 20, "iops": 10, "total_ios": 100,
+              "clat_ns": {"min": 2, "max": 90, "mean": 30, "stddev": 0, "percentile": {"99.000000": 80}}}},
+    {"jobname": "bg", "groupid": 0, "elapsed": 10, "write": {"bw_bytes": 1000, "iops": 1}},
+    {"jobname": "after", "groupid": 1, "elapsed": 5, "read": {"bw_bytes": 7}}
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []int{0, 1}; !reflect.DeepEqual(out.Groups(), exp) {
+		t.Errorf("groups = %v, expected %v", out.Groups(), exp)
+	}
+	if n := len(out.Group(0)); n != 3 {
+		t.Errorf("expected 3 jobs in group 0, got %d", n)
+	}
+	if n := len(out.JobsNamed("fg")); n != 2 {
+		t.Errorf("expected 2 jobs named fg, got %d", n)
+	}
+
+	fg := Aggregate(out.JobsNamed("fg"))
+	if fg.JobName != "fg" || fg.Elapsed != 11 {
+		t.Errorf("unexpected aggregate: %s (elapsed %v)", fg.JobName, fg.Elapsed)
+	}
+	if r := fg.Read; r.IOBytes != 300 || r.BWBytes != 30 || r.IOPS != 40 || r.TotalIOs != 400 {
+		t.Errorf("unexpected aggregate reads: %+v", r)
+	}
+	clat := fg.Read.ClatNS
+	if clat.Min != 2 || clat.Max != 90 || clat.Mean != 15 {
+		t.Errorf("unexpected aggregate latency: %+v", clat)
+	}
+	// Pooled: 3/4 of I/Os at 10ns, 1/4 at 30ns.
+	if exp := math.Sqrt(75); math.Abs(float64(clat.Stddev)-exp) > 1e-9 {
+		t.Errorf("stddev = %v, expected %v", clat.Stddev, exp)
+	}
+	if p99, _ := clat.Percentile(99); p99 != 80 {
+		t.Errorf("p99 = %v, expected the worst across jobs (80)", p99)
+	}
+
+	if all := Aggregate(out.Group(0)); all.Write.BWBytes != 1000 || all.Read.BWBytes != 30 {
+		t.Errorf("unexpected group aggregate: %+v", all)
+	}
+	if one := Aggregate(out.Group(1)); !reflect.DeepEqual(one, out.Jobs[3]) {
+		t.Errorf("expected a single job to aggregate to itself, got %+v", one)
+	}
+}
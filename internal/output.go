@@ -14,298 +14,216 @@
 
 package internal
 
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // Output represents the top-level JSON output for fio.
 type Output struct {
-	Jobs []Job `json:"jobs"`
+	FioVersion string `json:"fio version"`
+	Jobs       []Job  `json:"jobs"`
 }
 
 // Job represents the JSON output for each job.
 type Job struct {
+	JobName string `json:"jobname"`
+	GroupID int    `json:"groupid"`
+	Error   Number `json:"error"`   // errno, if the job failed
+	Elapsed Number `json:"elapsed"` // s, including ramp-up
+	// Options are the job's options as given to fio (e.g. "bs": "4k"),
+	// absent in terse outputs of older versions.
+	Options map[string]string `json:"job options"`
+
 	Read  ReadWriteStats `json:"read"`
 	Write ReadWriteStats `json:"write"`
 }
 
 // ReadWriteStats represents the JSON output for read/write statistics.
 type ReadWriteStats struct {
-	BWBytes int     `json:"bw_bytes"`
-	IOPS    float64 `json:"iops"`
+	IOBytes  Number `json:"io_bytes"` // KiB for fio < 3.0
+	BWBytes  Number `json:"bw_bytes"`
+	BW       Number `json:"bw"` // KiB/s
+	IOPS     Number `json:"iops"`
+	Runtime  Number `json:"runtime"` // ms
+	TotalIOs Number `json:"total_ios"`
 
-	BWMin     int     `json:"bw_min"`
-	BWMax     int     `json:"bw_max"`
-	BWAgg     float64 `json:"bw_agg"`
-	BWMean    float64 `json:"bw_mean"`
-	BWDev     float64 `json:"bw_dev"`
-	BWSamples int     `json:"bw_samples"`
+	BWMin     Number `json:"bw_min"`
+	BWMax     Number `json:"bw_max"`
+	BWAgg     Number `json:"bw_agg"`
+	BWMean    Number `json:"bw_mean"`
+	BWDev     Number `json:"bw_dev"`
+	BWSamples Number `json:"bw_samples"`
 
-	IOPSMin     int     `json:"iops_min"`
-	IOPSMax     int     `json:"iops_max"`
-	IOPSMean    float64 `json:"iops_mean"`
-	IOPSStddev  float64 `json:"iops_stddev"`
-	IOPSSamples int     `json:"iops_samples"`
+	IOPSMin     Number `json:"iops_min"`
+	IOPSMax     Number `json:"iops_max"`
+	IOPSMean    Number `json:"iops_mean"`
+	IOPSStddev  Number `json:"iops_stddev"`
+	IOPSSamples Number `json:"iops_samples"`
+
+	ClatNS LatencyStats `json:"clat_ns"`
+	ClatUS LatencyStats `json:"clat"` // fio < 3.0
+	LatNS  LatencyStats `json:"lat_ns"`
+	LatUS  LatencyStats `json:"lat"` // fio < 3.0
 }
 
-// NB: The full JSON output for fio looks as such:
-//
-// {
-//   "fio version" : "fio-3.30",
-//   "timestamp" : 1690585048,
-//   "timestamp_ms" : 1690585048882,
-//   "time" : "Fri Jul 28 18:57:28 2023",
-//   "jobs" : [
-//     {
-//       "jobname" : "write_throughput",
-//       "groupid" : 0,
-//       "error" : 0,
-//       "eta" : 0,
-//       "elapsed" : 13,
-//       "job options" : {
-//         "name" : "write_throughput",
-//         "directory" : "dir",
-//         "numjobs" : "8",
-//         "size" : "11GB",
-//         "runtime" : "10s",
-//         "ramp_time" : "2s",
-//         "ioengine" : "posixaio",
-//         "direct" : "1",
-//         "verify" : "0",
-//         "bs" : "1.0MB",
-//         "iodepth" : "64",
-//         "rw" : "write",
-//         "group_reporting" : "1"
-//       },
-//       "read" : {
-//         "io_bytes" : 0,
-//         "io_kbytes" : 0,
-//         "bw_bytes" : 0,
-//         "bw" : 0,
-//         "iops" : 0.000000,
-//         "runtime" : 0,
-//         "total_ios" : 0,
-//         "short_ios" : 0,
-//         "drop_ios" : 0,
-//         "slat_ns" : {
-//           "min" : 0,
-//           "max" : 0,
-//           "mean" : 0.000000,
-//           "stddev" : 0.000000,
-//           "N" : 0
-//         },
-//         "clat_ns" : {
-//           "min" : 0,
-//           "max" : 0,
-//           "mean" : 0.000000,
-//           "stddev" : 0.000000,
-//           "N" : 0
-//         },
-//         "lat_ns" : {
-//           "min" : 0,
-//           "max" : 0,
-//           "mean" : 0.000000,
-//           "stddev" : 0.000000,
-//           "N" : 0
-//         },
-//         "bw_min" : 0,
-//         "bw_max" : 0,
-//         "bw_agg" : 0.000000,
-//         "bw_mean" : 0.000000,
-//         "bw_dev" : 0.000000,
-//         "bw_samples" : 0,
-//         "iops_min" : 0,
-//         "iops_max" : 0,
-//         "iops_mean" : 0.000000,
-//         "iops_stddev" : 0.000000,
-//         "iops_samples" : 0
-//       },
-//       "write" : {
-//         "io_bytes" : 3752351,
-//         "io_kbytes" : 3664,
-//         "bw_bytes" : 375197,
-//         "bw" : 366,
-//         "iops" : 375191.380862,
-//         "runtime" : 10001,
-//         "total_ios" : 3752289,
-//         "short_ios" : 0,
-//         "drop_ios" : 0,
-//         "slat_ns" : {
-//           "min" : 0,
-//           "max" : 42889000,
-//           "mean" : 8255.047179,
-//           "stddev" : 329922.495494,
-//           "N" : 3752294
-//         },
-//         "clat_ns" : {
-//           "min" : 1000,
-//           "max" : 48451000,
-//           "mean" : 210681.698074,
-//           "stddev" : 1392251.256053,
-//           "N" : 3752321,
-//           "percentile" : {
-//             "1.000000" : 11968,
-//             "5.000000" : 20096,
-//             "10.000000" : 28032,
-//             "20.000000" : 42240,
-//             "30.000000" : 57088,
-//             "40.000000" : 75264,
-//             "50.000000" : 105984,
-//             "60.000000" : 179200,
-//             "70.000000" : 211968,
-//             "80.000000" : 222208,
-//             "90.000000" : 240640,
-//             "95.000000" : 337920,
-//             "99.000000" : 806912,
-//             "99.500000" : 1351680,
-//             "99.900000" : 32112640,
-//             "99.950000" : 34340864,
-//             "99.990000" : 37486592
-//           }
-//         },
-//         "lat_ns" : {
-//           "min" : 6000,
-//           "max" : 48452000,
-//           "mean" : 218936.727695,
-//           "stddev" : 1430845.458417,
-//           "N" : 3752321
-//         },
-//         "bw_min" : 290,
-//         "bw_max" : 435,
-//         "bw_agg" : 98.798231,
-//         "bw_mean" : 362.157895,
-//         "bw_dev" : 4.330379,
-//         "bw_samples" : 152,
-//         "iops_min" : 301919,
-//         "iops_max" : 448494,
-//         "iops_mean" : 374900.210526,
-//         "iops_stddev" : 4417.469684,
-//         "iops_samples" : 152
-//       },
-//       "trim" : {
-//         "io_bytes" : 0,
-//         "io_kbytes" : 0,
-//         "bw_bytes" : 0,
-//         "bw" : 0,
-//         "iops" : 0.000000,
-//         "runtime" : 0,
-//         "total_ios" : 0,
-//         "short_ios" : 0,
-//         "drop_ios" : 0,
-//         "slat_ns" : {
-//           "min" : 0,
-//           "max" : 0,
-//           "mean" : 0.000000,
-//           "stddev" : 0.000000,
-//           "N" : 0
-//         },
-//         "clat_ns" : {
-//           "min" : 0,
-//           "max" : 0,
-//           "mean" : 0.000000,
-//           "stddev" : 0.000000,
-//           "N" : 0
-//         },
-//         "lat_ns" : {
-//           "min" : 0,
-//           "max" : 0,
-//           "mean" : 0.000000,
-//           "stddev" : 0.000000,
-//           "N" : 0
-//         },
-//         "bw_min" : 0,
-//         "bw_max" : 0,
-//         "bw_agg" : 0.000000,
-//         "bw_mean" : 0.000000,
-//         "bw_dev" : 0.000000,
-//         "bw_samples" : 0,
-//         "iops_min" : 0,
-//         "iops_max" : 0,
-//         "iops_mean" : 0.000000,
-//         "iops_stddev" : 0.000000,
-//         "iops_samples" : 0
-//       },
-//       "sync" : {
-//         "total_ios" : 0,
-//         "lat_ns" : {
-//           "min" : 0,
-//           "max" : 0,
-//           "mean" : 0.000000,
-//           "stddev" : 0.000000,
-//           "N" : 0
-//         }
-//       },
-//       "job_runtime" : 80000,
-//       "usr_cpu" : 5.226250,
-//       "sys_cpu" : 10.048750,
-//       "ctx" : 2390859,
-//       "majf" : 0,
-//       "minf" : 81,
-//       "iodepth_level" : {
-//         "1" : 1.597745,
-//         "2" : 8.348291,
-//         "4" : 21.887200,
-//         "8" : 61.037383,
-//         "16" : 7.129382,
-//         "32" : 0.000000,
-//         ">=64" : 0.000000
-//       },
-//       "iodepth_submit" : {
-//         "0" : 0.000000,
-//         "4" : 100.000000,
-//         "8" : 0.000000,
-//         "16" : 0.000000,
-//         "32" : 0.000000,
-//         "64" : 0.000000,
-//         ">=64" : 0.000000
-//       },
-//       "iodepth_complete" : {
-//         "0" : 0.000000,
-//         "4" : 94.514181,
-//         "8" : 2.073844,
-//         "16" : 3.411975,
-//         "32" : 0.000000,
-//         "64" : 0.000000,
-//         ">=64" : 0.000000
-//       },
-//       "latency_ns" : {
-//         "2" : 0.000000,
-//         "4" : 0.000000,
-//         "10" : 0.000000,
-//         "20" : 0.000000,
-//         "50" : 0.000000,
-//         "100" : 0.000000,
-//         "250" : 0.000000,
-//         "500" : 0.000000,
-//         "750" : 0.000000,
-//         "1000" : 0.000000
-//       },
-//       "latency_us" : {
-//         "2" : 0.010000,
-//         "4" : 0.010000,
-//         "10" : 0.425287,
-//         "20" : 4.188936,
-//         "50" : 20.835282,
-//         "100" : 23.054701,
-//         "250" : 42.379865,
-//         "500" : 7.327607,
-//         "750" : 0.693017,
-//         "1000" : 0.352798
-//       },
-//       "latency_ms" : {
-//         "2" : 0.386564,
-//         "4" : 0.074701,
-//         "10" : 0.055859,
-//         "20" : 0.046665,
-//         "50" : 0.172242,
-//         "100" : 0.000000,
-//         "250" : 0.000000,
-//         "500" : 0.000000,
-//         "750" : 0.000000,
-//         "1000" : 0.000000,
-//         "2000" : 0.000000,
-//         ">=2000" : 0.000000
-//       },
-//       "latency_depth" : 64,
-//       "latency_target" : 0,
-//       "latency_percentile" : 100.000000,
-//       "latency_window" : 0
-//     }
-//   ]
-// }
+// LatencyStats represents the JSON output for latency statistics.
+type LatencyStats struct {
+	Min         Number            `json:"min"`
+	Max         Number            `json:"max"`
+	Mean        Number            `json:"mean"`
+	Stddev      Number            `json:"stddev"`
+	Percentiles map[string]Number `json:"percentile"`
+}
+
+// Percentile returns the given percentile (e.g. 99.9), if recorded.
+func (l LatencyStats) Percentile(p float64) (Number, bool) {
+	for k, v := range l.Percentiles {
+		f, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			continue
+		}
+		if f == p {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+func (l LatencyStats) scaled(factor float64) LatencyStats {
+	scaled := LatencyStats{
+		Min:    l.Min * Number(factor),
+		Max:    l.Max * Number(factor),
+		Mean:   l.Mean * Number(factor),
+		Stddev: l.Stddev * Number(factor),
+	}
+	if l.Percentiles != nil {
+		scaled.Percentiles = make(map[string]Number, len(l.Percentiles))
+		for k, v := range l.Percentiles {
+			scaled.Percentiles[k] = v * Number(factor)
+		}
+	}
+	return scaled
+}
+
+// Number is a JSON number that decodes regardless of whether fio rendered it
+// as an integer, a float, or a quoted string, all of which have been seen
+// across fio versions for the same field.
+type Number float64
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (n *Number) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if bytes.Equal(b, []byte("null")) {
+		*n = 0
+		return nil
+	}
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		b = []byte(strings.TrimSpace(s))
+	}
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", b)
+	}
+	*n = Number(f)
+	return nil
+}
+
+// Version is a parsed fio version, e.g. "fio-3.30".
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses fio's self-reported version string. Versions built from
+// source carry a git suffix (e.g. "fio-3.35-12-gabcdef"), which is ignored.
+func ParseVersion(s string) (Version, error) {
+	str := strings.TrimPrefix(strings.TrimSpace(s), "fio-")
+	if i := strings.IndexAny(str, "-+ "); i >= 0 {
+		str = str[:i]
+	}
+	parts := strings.Split(str, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Version{}, fmt.Errorf("unrecognized fio version %q", s)
+	}
+	var nums [3]int
+	for i, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			return Version{}, fmt.Errorf("unrecognized fio version %q", s)
+		}
+		nums[i] = num
+	}
+	return Version{Major: nums[0], Minor: nums[1], Patch: nums[2]}, nil
+}
+
+// Less returns whether v is older than o.
+func (v Version) Less(o Version) bool {
+	if v.Major != o.Major {
+		return v.Major < o.Major
+	}
+	if v.Minor != o.Minor {
+		return v.Minor < o.Minor
+	}
+	return v.Patch < o.Patch
+}
+
+func (v Version) String() string {
+	if v.Patch != 0 {
+		return fmt.Sprintf("fio-%d.%d.%d", v.Major, v.Minor, v.Patch)
+	}
+	return fmt.Sprintf("fio-%d.%d", v.Major, v.Minor)
+}
+
+// Decode decodes fio's JSON output, normalizing differences across fio
+// versions: older versions only report bandwidth in KiB/s, latencies in
+// microseconds and I/O volumes in KiB, which are converted to bytes/s,
+// nanoseconds and bytes respectively.
+func Decode(data []byte) (Output, error) {
+	var out Output
+	if err := json.Unmarshal(data, &out); err != nil {
+		var sniff struct {
+			FioVersion string `json:"fio version"`
+		}
+		if json.Unmarshal(data, &sniff) == nil && sniff.FioVersion != "" {
+			return Output{}, fmt.Errorf("decoding %s output: %w", sniff.FioVersion, err)
+		}
+		return Output{}, fmt.Errorf("decoding fio output: %w", err)
+	}
+	if len(out.Jobs) == 0 {
+		return Output{}, fmt.Errorf("decoding fio output: no jobs found")
+	}
+	// Outputs of unrecognized versions are taken to be recent.
+	version, _ := ParseVersion(out.FioVersion)
+	for i := range out.Jobs {
+		out.Jobs[i].Read.normalize(version)
+		out.Jobs[i].Write.normalize(version)
+	}
+	return out, nil
+}
+
+func (s *ReadWriteStats) normalize(v Version) {
+	if v.Major != 0 && v.Less(Version{Major: 3}) {
+		// fio switched io_bytes to bytes in 3.0, adding io_kbytes for KiB.
+		s.IOBytes *= 1024
+	}
+	if s.BWBytes == 0 && s.BW != 0 {
+		s.BWBytes = s.BW * 1024
+	}
+	if s.ClatNS.Max == 0 && s.ClatUS.Max != 0 {
+		s.ClatNS = s.ClatUS.scaled(1e3)
+	}
+	if s.LatNS.Max == 0 && s.LatUS.Max != 0 {
+		s.LatNS = s.LatUS.scaled(1e3)
+	}
+}
+
+// NB: A full (recorded) JSON output for fio-3.30 can be found under
+// testdata/, alongside outputs from other versions that the decoding above is
+// tested against.
@@ -0,0 +1,166 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDecodeCorpus decodes fio JSON outputs from several fio versions (see
+// testdata/), checking that fields that changed shape across versions are
+// normalized.
+func TestDecodeCorpus(t *testing.T) {
+	for _, tc := range []struct {
+		file         string
+		version      Version
+		readBW       Number
+		writeBW      Number
+		readIOPS     Number
+		writeIOPS    Number
+		clatP99      Number // for whichever direction did I/O, in ns
+		writeIOPSMin Number
+		ioBytes      Number // for whichever direction did I/O
+	}{
+		{
+			file:      "fio-2.2.10.json",
+			version:   Version{Major: 2, Minor: 2, Patch: 10},
+			writeBW:   524288 * 1024,
+			writeIOPS: 512,
+			clatP99:   171008 * 1e3,
+			ioBytes:   5 << 30, // 512MiB/s for 10s
+		},
+		{
+			file:     "fio-3.1.json",
+			version:  Version{Major: 3, Minor: 1},
+			readBW:   292846272,
+			readIOPS: 71495.67,
+			clatP99:  1400832,
+			ioBytes:  2928754688,
+		},
+		{
+			file:         "fio-3.30.json",
+			version:      Version{Major: 3, Minor: 30},
+			writeBW:      375197,
+			writeIOPS:    375191.380862,
+			clatP99:      806912,
+			writeIOPSMin: 301919,
+			ioBytes:      3752351,
+		},
+		{
+			file:     "fio-3.35.json",
+			version:  Version{Major: 3, Minor: 35},
+			readBW:   3650357312,
+			readIOPS: 3481.252119,
+			clatP99:  227540992,
+			ioBytes:  36507222016,
+		},
+	} {
+		t.Run(strings.TrimSuffix(tc.file, ".json"), func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := Decode(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			version, err := ParseVersion(out.FioVersion)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version != tc.version {
+				t.Errorf("version = %s, expected %s", version, tc.version)
+			}
+
+			job := out.Jobs[0]
+			if job.Read.BWBytes != tc.readBW {
+				t.Errorf("read bw = %v, expected %v", job.Read.BWBytes, tc.readBW)
+			}
+			if job.Write.BWBytes != tc.writeBW {
+				t.Errorf("write bw = %v, expected %v", job.Write.BWBytes, tc.writeBW)
+			}
+			if job.Read.IOPS != tc.readIOPS {
+				t.Errorf("read iops = %v, expected %v", job.Read.IOPS, tc.readIOPS)
+			}
+			if job.Write.IOPS != tc.writeIOPS {
+				t.Errorf("write iops = %v, expected %v", job.Write.IOPS, tc.writeIOPS)
+			}
+			if job.Write.IOPSMin != tc.writeIOPSMin {
+				t.Errorf("write iops min = %v, expected %v", job.Write.IOPSMin, tc.writeIOPSMin)
+			}
+
+			stats := job.Read
+			if tc.writeBW != 0 {
+				stats = job.Write
+			}
+			if stats.IOBytes != tc.ioBytes {
+				t.Errorf("io bytes = %v, expected %v", stats.IOBytes, tc.ioBytes)
+			}
+			clat := stats.ClatNS
+			if p99, ok := clat.Percentile(99); !ok || p99 != tc.clatP99 {
+				t.Errorf("clat p99 = %v (ok = %t), expected %v", p99, ok, tc.clatP99)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tc := range []struct {
+		input, expErr string
+	}{
+		{
+			input:  `{"fio version": "fio-3.30", "jobs": [{"read": {"iops": "abc"}}]}`,
+			expErr: "decoding fio-3.30 output",
+		},
+		{
+			input:  `{"fio version": "fio-3.30", "jobs": []}`,
+			expErr: "no jobs found",
+		},
+		{
+			input:  `fio: file hash not empty`,
+			expErr: "decoding fio output",
+		},
+	} {
+		_, err := Decode([]byte(tc.input))
+		if err == nil || !strings.Contains(err.Error(), tc.expErr) {
+			t.Errorf("Decode(%q) = %v, expected error containing %q", tc.input, err, tc.expErr)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for _, tc := range []struct {
+		input string
+		exp   Version
+		ok    bool
+	}{
+		{"fio-3.30", Version{3, 30, 0}, true},
+		{"fio-2.2.10", Version{2, 2, 10}, true},
+		{"fio-3.35-12-gabcdef\n", Version{3, 35, 0}, true},
+		{"fio-3", Version{}, false},
+		{"banana", Version{}, false},
+	} {
+		v, err := ParseVersion(tc.input)
+		if ok := err == nil; ok != tc.ok || v != tc.exp {
+			t.Errorf("ParseVersion(%q) = %v, %v; expected %v (ok = %t)", tc.input, v, err, tc.exp, tc.ok)
+		}
+	}
+	if !(Version{2, 2, 10}).Less(Version{3, 1, 0}) {
+		t.Error("expected fio-2.2.10 < fio-3.1")
+	}
+}
@@ -0,0 +1,324 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Job builds up a fio job, i.e. the arguments fio is invoked with to run it.
+// It has typed setters for the options probes use, and Set and Flag for
+// anything else. Options can only be set once: setting one twice, or setting
+// conflicting options (e.g. bs and bssplit), is an error, reported by Args.
+//
+//	args, err := probe.NewJob("reads").
+//		Directory("/mnt/data/probe").
+//		ReadWrite(probe.RandRead).
+//		BlockSize(4 << 10).
+//		Set("percentile_list", "50:99:99.9").
+//		Args()
+type Job struct {
+	name    string
+	options []jobOption
+	set     map[string]bool
+	err     error
+}
+
+// jobOption is a fio option, with a nil value for valueless ones.
+type jobOption struct {
+	key   string
+	value *string
+}
+
+// NewJob returns a job with the given name.
+func NewJob(name string) *Job {
+	return &Job{name: name, set: make(map[string]bool)}
+}
+
+// Name returns the job's name.
+func (j *Job) Name() string {
+	return j.name
+}
+
+// jobAliases maps alternative spellings of fio options to the ones used here,
+// so both spellings count as the same option.
+var jobAliases = map[string]string{
+	"blocksize": "bs",
+	"readwrite": "rw",
+	"timeout":   "runtime",
+}
+
+// canonicalOption returns the spelling of the given fio option (without
+// leading dashes) used here.
+func canonicalOption(key string) string {
+	if alias, ok := jobAliases[key]; ok {
+		return alias
+	}
+	return key
+}
+
+// jobConflicts are options that can't be used together, as one overrides the
+// other in ways that are easy to miss.
+var jobConflicts = [][2]string{
+	{"bs", "bssplit"},
+	{"direct", "buffered"},
+}
+
+// Set sets the given fio option (e.g. "bs", without leading dashes) to the
+// given value.
+func (j *Job) Set(key, value string) *Job {
+	return j.add(key, &value)
+}
+
+// Flag sets the given valueless fio option (e.g. "time_based").
+func (j *Job) Flag(key string) *Job {
+	return j.add(key, nil)
+}
+
+func (j *Job) add(key string, value *string) *Job {
+	if j.err != nil {
+		return j
+	}
+	key = strings.TrimLeft(key, "-")
+	canonical := canonicalOption(key)
+	switch {
+	case key == "":
+		j.err = fmt.Errorf("job %s: empty fio option", j.name)
+	case canonical == "name":
+		// This would start a new job, with the remaining options applying
+		// to it instead.
+		j.err = fmt.Errorf("job %s: fio option %q can't be set", j.name, key)
+	case strings.HasPrefix(canonical, "output"):
+		j.err = fmt.Errorf("job %s: fio option %q can't be set, as the probe parses fio's output", j.name, key)
+	case j.set[canonical]:
+		j.err = fmt.Errorf("job %s: fio option %q set twice", j.name, key)
+	}
+	for _, c := range jobConflicts {
+		for i := range c {
+			if canonical == c[i] && j.set[c[1-i]] {
+				j.err = fmt.Errorf("job %s: fio option %q conflicts with %q", j.name, key, c[1-i])
+			}
+		}
+	}
+	if j.err != nil {
+		return j
+	}
+	j.set[canonical] = true
+	j.options = append(j.options, jobOption{key: key, value: value})
+	return j
+}
+
+// Extra parses and sets options given as fio command line arguments, each of
+// the form --key=value or --key (for valueless options).
+func (j *Job) Extra(args ...string) *Job {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			if j.err == nil {
+				j.err = fmt.Errorf("job %s: invalid fio argument %q, want --key=value or --key", j.name, arg)
+			}
+			return j
+		}
+		if key, value, ok := strings.Cut(arg, "="); ok {
+			j.Set(key, value)
+		} else {
+			j.Flag(arg)
+		}
+	}
+	return j
+}
+
+// Directory sets the directory the job lays out its files in.
+func (j *Job) Directory(dir string) *Job {
+	return j.Set("directory", dir)
+}
+
+// Size sets the size of each of the job's files.
+func (j *Job) Size(bytes uint64) *Job {
+	return j.Set("size", fmt.Sprint(bytes))
+}
+
+// Runtime has the job run for the given duration, regardless of how much of
+// its files it gets through.
+func (j *Job) Runtime(d time.Duration) *Job {
+	return j.Flag("time_based").Set("runtime", fmt.Sprintf("%ds", int(d.Seconds())))
+}
+
+// RampTime sets how long the job runs before measurements are recorded.
+func (j *Job) RampTime(d time.Duration) *Job {
+	return j.Set("ramp_time", fmt.Sprintf("%ds", int(d.Seconds())))
+}
+
+// IOEngine sets the I/O engine the job issues I/O with.
+func (j *Job) IOEngine(engine IOEngine) *Job {
+	return j.Set("ioengine", string(engine))
+}
+
+// Direct sets whether the job bypasses the page cache (O_DIRECT).
+func (j *Job) Direct(direct bool) *Job {
+	if direct {
+		return j.Set("direct", "1")
+	}
+	return j.Set("direct", "0")
+}
+
+// ReadWrite sets the job's I/O pattern.
+func (j *Job) ReadWrite(rw ReadWrite) *Job {
+	return j.Set("rw", string(rw))
+}
+
+// ReadMix sets the percentage of I/O that are reads, for mixed patterns.
+func (j *Job) ReadMix(percent int) *Job {
+	return j.Set("rwmixread", fmt.Sprint(percent))
+}
+
+// RandomDistribution sets how the job's random I/O offsets are distributed.
+func (j *Job) RandomDistribution(d RandomDistribution) *Job {
+	if d == Uniform {
+		return j
+	}
+	return j.Set("random_distribution", string(d))
+}
+
+// PercentageRandom sets the percentage of the job's I/O that's random, with
+// the rest sequential.
+func (j *Job) PercentageRandom(percent int) *Job {
+	return j.Set("percentage_random", fmt.Sprint(percent))
+}
+
+// BlockSize sets the job's I/O block size.
+func (j *Job) BlockSize(bytes uint64) *Job {
+	return j.Set("bs", fmt.Sprint(bytes))
+}
+
+// BlockSizeSplit sets the mix of block sizes the job's reads and writes use.
+func (j *Job) BlockSizeSplit(reads, writes BlockSizeSplit) *Job {
+	if reads.String() == writes.String() {
+		return j.Set("bssplit", reads.String())
+	}
+	return j.Set("bssplit", reads.String()+","+writes.String())
+}
+
+// IODepth sets the number of I/O units the job keeps in flight.
+func (j *Job) IODepth(depth int) *Job {
+	return j.Set("iodepth", fmt.Sprint(depth))
+}
+
+// NumJobs runs the given number of clones of the job in parallel.
+func (j *Job) NumJobs(n uint64) *Job {
+	return j.Set("numjobs", fmt.Sprint(n))
+}
+
+// Rate caps the job's bandwidth, in bytes/s.
+func (j *Job) Rate(bytesPerSecond uint64) *Job {
+	return j.Set("rate", fmt.Sprint(bytesPerSecond))
+}
+
+// RateIOPS caps the job's IOPS.
+func (j *Job) RateIOPS(iops uint64) *Job {
+	return j.Set("rate_iops", fmt.Sprint(iops))
+}
+
+// Fsync has the job fsync its file after every given number of writes.
+func (j *Job) Fsync(every int) *Job {
+	return j.Set("fsync", fmt.Sprint(every))
+}
+
+// GroupReporting has fio report the stats of the job's clones (see NumJobs)
+// as one.
+func (j *Job) GroupReporting() *Job {
+	return j.Set("group_reporting", "1")
+}
+
+// IOPriority sets the I/O priority the job issues I/O with. fio applies it
+// with ioprio_set(2), so it holds for any I/O engine.
+func (j *Job) IOPriority(p IOPriority) *Job {
+	if p.Class == 0 {
+		return j
+	}
+	j.Set("prioclass", fmt.Sprint(int(p.Class)))
+	if p.Class != IdleClass {
+		j.Set("prio", fmt.Sprint(p.Level))
+	}
+	return j
+}
+
+// DataPattern shapes the contents of the job's written buffers.
+func (j *Job) DataPattern(d DataPattern) *Job {
+	if d.CompressPercentage != 0 {
+		j.Set("buffer_compress_percentage", fmt.Sprint(d.CompressPercentage))
+	}
+	if d.DedupePercentage != 0 {
+		j.Set("dedupe_percentage", fmt.Sprint(d.DedupePercentage))
+	}
+	if d.RefillBuffers {
+		j.Flag("refill_buffers")
+	}
+	// fio scrambles buffers unless told otherwise, so always say.
+	scramble := d.ScrambleBuffers != nil && *d.ScrambleBuffers
+	return j.Set("scramble_buffers", fmt.Sprint(boolToInt(scramble)))
+}
+
+// Args returns the job's fio arguments, or the first error encountered while
+// building it up. Values are joined to their options (--key=value), as fio
+// only takes a separate value for options whose value isn't optional (e.g.
+// group_reporting's is), reading it as a job file otherwise.
+func (j *Job) Args() ([]string, error) {
+	if j.err != nil {
+		return nil, j.err
+	}
+	args := []string{"--name=" + j.name}
+	for _, o := range j.options {
+		if o.value == nil {
+			args = append(args, "--"+o.key)
+		} else {
+			args = append(args, "--"+o.key+"="+*o.value)
+		}
+	}
+	return args, nil
+}
+
+// INI returns the job as a fio job file, i.e. what can be run as "fio
+// <file>" to the same effect as running fio with Args.
+func (j *Job) INI() (string, error) {
+	if j.err != nil {
+		return "", j.err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s]\n", j.name)
+	for _, o := range j.options {
+		if o.value == nil {
+			fmt.Fprintf(&b, "%s\n", o.key)
+		} else {
+			fmt.Fprintf(&b, "%s=%s\n", o.key, *o.value)
+		}
+	}
+	return b.String(), nil
+}
+
+// commandLine returns the fio arguments running the given jobs concurrently,
+// reporting results as JSON.
+func commandLine(jobs ...*Job) ([]string, error) {
+	var args []string
+	for _, j := range jobs {
+		jargs, err := j.Args()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, jargs...)
+	}
+	return append(args, "--output-format=json"), nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/fiotest"
+)
+
+func TestJob(t *testing.T) {
+	args, err := probe.NewJob("reads").
+		Directory("/mnt/probe").
+		ReadWrite(probe.RandRead).
+		BlockSize(4<<10).
+		Runtime(30*time.Second).
+		Set("percentile_list", "50:99").
+		Extra("--norandommap", "--random_generator=lfsr").
+		Args()
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{
+		"--name=reads", "--directory=/mnt/probe", "--rw=randread", "--bs=4096",
+		"--time_based", "--runtime=30s", "--percentile_list=50:99",
+		"--norandommap", "--random_generator=lfsr",
+	}
+	if !reflect.DeepEqual(args, exp) {
+		t.Errorf("args = %q, expected %q", args, exp)
+	}
+
+	for _, tc := range []struct {
+		job *probe.Job
+		err string
+	}{
+		{probe.NewJob("j").BlockSize(4096).BlockSize(8192), `"bs" set twice`},
+		{probe.NewJob("j").BlockSize(4096).Set("blocksize", "8k"), `"blocksize" set twice`},
+		{probe.NewJob("j").BlockSize(4096).Extra("--bssplit=4k/50:8k/50"), `"bssplit" conflicts with "bs"`},
+		{probe.NewJob("j").Direct(true).Flag("buffered"), `"buffered" conflicts with "direct"`},
+		{probe.NewJob("j").Extra("--name=other"), `"name" can't be set`},
+		{probe.NewJob("j").Extra("--output-format=normal"), "can't be set"},
+		{probe.NewJob("j").Extra("bs=4k"), "invalid fio argument"},
+	} {
+		if _, err := tc.job.Args(); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected error containing %q, got %v", tc.err, err)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	args, err := probe.DryRun(context.Background(),
+		probe.WithKind(probe.ReadBandwidth),
+		probe.WithDirectory(dir),
+		probe.WithDuration(10*time.Second),
+		probe.WithSize(16<<20),
+		probe.WithReservedSpace(0),
+		probe.WithRunner(probe.ExecRunner{Path: "/opt/fio/bin/fio"}),
+		probe.WithExtraArgs([]string{"--percentile_list=50:99"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != "/opt/fio/bin/fio" {
+		t.Errorf("expected fio's path first, got %q", args)
+	}
+	var bs int
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "--bs=") {
+			bs++
+		}
+	}
+	if cmd := strings.Join(args, " "); bs != 1 || !strings.Contains(cmd, "--bs=1048576") ||
+		!strings.Contains(cmd, "--percentile_list=50:99") {
+		t.Errorf("unexpected command line: %s", cmd)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected dry run to leave %s untouched, got %v", dir, err)
+	}
+}
+
+func TestDryRunArgs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	args, err := probe.DryRun(context.Background(),
+		probe.WithKind(probe.ReadBandwidth),
+		probe.WithDirectory(dir),
+		probe.WithDuration(10*time.Second),
+		probe.WithSize(16<<20),
+		probe.WithReservedSpace(0),
+		probe.WithRunner(probe.ExecRunner{Path: "fio"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fio reads a value that isn't joined to an option taking an optional
+	// one (e.g. group_reporting) as a job file, so check argv exactly.
+	exp := []string{
+		"fio", "--name=read_bandwidth", "--directory=" + dir, "--size=2097152",
+		"--time_based", "--runtime=10s", "--ramp_time=2s", "--ioengine=libaio",
+		"--direct=1", "--verify=0", "--thread", "--rw=read", "--iodepth=64",
+		"--group_reporting=1", "--bs=1048576", "--numjobs=8", "--output-format=json",
+	}
+	if !reflect.DeepEqual(args, exp) {
+		t.Errorf("args = %q, expected %q", args, exp)
+	}
+}
+
+func TestDryRunWithoutKind(t *testing.T) {
+	_, err := probe.DryRun(context.Background(), probe.WithDirectory(t.TempDir()))
+	if err == nil || !strings.Contains(err.Error(), "kind unspecified") {
+		t.Errorf("expected unspecified kind error, got %v", err)
+	}
+}
+
+func TestFakeExtraArgs(t *testing.T) {
+	runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{})}
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.ReadIOPS))
+
+	res, err := probe.Run(context.Background(), append(opts,
+		probe.WithExtraArgs([]string{"--norandommap"}))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(runner.Calls()[0], " "), "--norandommap") {
+		t.Errorf("expected extra args in: %s", runner.Calls()[0])
+	}
+	if exp := "/extra=--norandommap"; !strings.HasSuffix(res.Config.String(), exp) {
+		t.Errorf("expected %q in config: %s", exp, res.Config)
+	}
+
+	_, err = probe.Run(context.Background(), append(opts,
+		probe.WithExtraArgs([]string{"--iodepth=1"}))...)
+	if err == nil || !strings.Contains(err.Error(), `"iodepth" set twice`) {
+		t.Errorf("expected duplicate option error, got %v", err)
+	}
+	for _, arg := range []string{"--size=1t", "--numjobs=64", "--filename=/dev/sda", "--directory=/", "--timeout=1h"} {
+		_, err = probe.Run(context.Background(), append(opts, probe.WithExtraArgs([]string{arg}))...)
+		if err == nil || !strings.Contains(err.Error(), "the probe manages it") {
+			t.Errorf("%s: expected probe-managed option error, got %v", arg, err)
+		}
+	}
+	if len(runner.Calls()) != 1 {
+		t.Errorf("expected fio not to run with invalid arguments")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+// IOLimits are limits on a device's I/O, e.g. as configured through cgroups.
+// Zero values are unlimited.
+type IOLimits struct {
+	ReadBPS   uint64 `json:"read_bps,omitempty"`
+	WriteBPS  uint64 `json:"write_bps,omitempty"`
+	ReadIOPS  uint64 `json:"read_iops,omitempty"`
+	WriteIOPS uint64 `json:"write_iops,omitempty"`
+}
+
+// Limit returns the limit applicable to the given kind of probe, and zero if
+// unlimited.
+func (l IOLimits) Limit(kind Kind) uint64 {
+	switch kind {
+	case ReadBandwidth:
+		return l.ReadBPS
+	case WriteBandwidth:
+		return l.WriteBPS
+	case ReadIOPS:
+		return l.ReadIOPS
+	case WriteIOPS:
+		return l.WriteIOPS
+	default:
+		return 0
+	}
+}
+
+// CgroupLimits returns the I/O limits the calling process's cgroups impose on
+// the device backing the given directory, i.e. the strictest io.max (cgroup
+// v2) or blkio.throttle.* (cgroup v1) limits across the process's cgroup and
+// its ancestors. Probes of a throttled device measure the throttle, not the
+// device.
+func CgroupLimits(dir string) (IOLimits, error) {
+	device, err := DeviceOf(dir)
+	if err != nil {
+		return IOLimits{}, err
+	}
+	return cgroupLimits(device)
+}
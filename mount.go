@@ -0,0 +1,53 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// mountOf returns the mount with the longest mount point containing the given
+// directory.
+func mountOf(dir string) (disk.PartitionStat, bool) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return disk.PartitionStat{}, false
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	partitions, err := disk.Partitions(true)
+	if err != nil {
+		return disk.PartitionStat{}, false
+	}
+	var mount disk.PartitionStat
+	for _, p := range partitions {
+		if !containsPath(p.Mountpoint, abs) || len(p.Mountpoint) < len(mount.Mountpoint) {
+			continue
+		}
+		mount = p
+	}
+	return mount, mount.Mountpoint != ""
+}
+
+func containsPath(parent, path string) bool {
+	if parent == "/" || parent == path {
+		return true
+	}
+	return strings.HasPrefix(path, parent+"/")
+}
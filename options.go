@@ -17,6 +17,7 @@ package probe
 import (
 	"fmt"
 	"io"
+	"strings"
 	"time"
 )
 
@@ -38,7 +39,8 @@ func WithDirectory(dir string) Option {
 	}
 }
 
-// WithDuration controls how long we record measurements for.
+// WithDuration controls how long we record measurements for. If unspecified,
+// it's derived from the context deadline (if any), and is 60s otherwise.
 func WithDuration(dur time.Duration) Option {
 	return func(opts *options) {
 		opts.Duration = dur
@@ -52,13 +54,32 @@ func WithRamp(ramp time.Duration) Option {
 	}
 }
 
-// WithSize controls how many bytes are written to disk during the probe.
+// WithSize controls how many bytes are laid out on disk during the probe,
+// across all jobs. It defaults to 10 GiB.
 func WithSize(size uint64) Option {
 	return func(opts *options) {
 		opts.Size = size
 	}
 }
 
+// WithMaxDiskFraction sizes the probe to use at most the given fraction (in
+// (0, 1]) of the disk space available to us. Used alone, it sizes the probe
+// off of available space; used with WithSize, it caps the configured size.
+func WithMaxDiskFraction(fraction float64) Option {
+	return func(opts *options) {
+		opts.MaxDiskFraction = fraction
+	}
+}
+
+// WithReservedSpace controls how much disk space the probe leaves untouched,
+// i.e. it refuses to run if doing so would leave less than the given number of
+// bytes available. It defaults to 5 GiB.
+func WithReservedSpace(reserved uint64) Option {
+	return func(opts *options) {
+		opts.Reserved = reserved
+	}
+}
+
 // WithMaxRate limits the probe to a maximum bandwidth (if a {read,write}
 // bandwidth probe) or IOPS (if a {read,write} IOPS probe).
 func WithMaxRate(rate uint64) Option {
@@ -67,6 +88,165 @@ func WithMaxRate(rate uint64) Option {
 	}
 }
 
+// WithReadWrite configures the I/O pattern, which otherwise follows from the
+// kind of probe: sequential I/O for bandwidth probes, random I/O for IOPS
+// probes. It must issue the kind of I/O being measured; mixed patterns issue
+// both reads and writes (see WithReadMix).
+func WithReadWrite(rw ReadWrite) Option {
+	return func(opts *options) {
+		opts.ReadWrite = rw
+	}
+}
+
+// WithReadMix configures the percentage of I/O that are reads, for mixed I/O
+// patterns. It defaults to 50.
+func WithReadMix(percent int) Option {
+	return func(opts *options) {
+		opts.ReadMix = &percent
+	}
+}
+
+// WithBlockSize configures the I/O block size, which otherwise defaults to
+// 1MiB for bandwidth probes and 4KiB for IOPS probes.
+func WithBlockSize(bs uint64) Option {
+	return func(opts *options) {
+		opts.BlockSize = bs
+	}
+}
+
+// WithBlockSizeSplit has the probe issue a weighted mix of block sizes rather
+// than a single one, separately for reads and writes. Either may be nil, in
+// which case that direction uses the block size (see WithBlockSize).
+func WithBlockSizeSplit(reads, writes BlockSizeSplit) Option {
+	return func(opts *options) {
+		opts.ReadBlockSizes, opts.WriteBlockSizes = reads, writes
+	}
+}
+
+// WithIODepth configures the number of I/O units each job keeps in flight.
+// It defaults to 64.
+func WithIODepth(depth int) Option {
+	return func(opts *options) {
+		opts.IODepth = depth
+	}
+}
+
+// WithJobs configures the number of parallel fio jobs, which otherwise
+// defaults to 8 for bandwidth probes and 1 for IOPS probes.
+func WithJobs(jobs uint64) Option {
+	return func(opts *options) {
+		opts.Jobs = jobs
+	}
+}
+
+// WithRandomDistribution configures how random I/O offsets are distributed,
+// e.g. Zipf(1.2). It only applies to random I/O patterns (see WithReadWrite).
+func WithRandomDistribution(d RandomDistribution) Option {
+	return func(opts *options) {
+		opts.RandomDistribution = d
+	}
+}
+
+// WithPercentageRandom has the given percentage of I/O be random, with the
+// rest sequential, for partially sequential streams. It only applies to
+// random I/O patterns (see WithReadWrite).
+func WithPercentageRandom(percent int) Option {
+	return func(opts *options) {
+		opts.PercentageRandom = &percent
+	}
+}
+
+// WithBufferedIO has the probe issue I/O through the page cache, rather than
+// bypassing it (O_DIRECT) as it does by default.
+func WithBufferedIO() Option {
+	return func(opts *options) {
+		opts.Buffered = true
+	}
+}
+
+// WithFsync has each job fsync its file after every given number of writes,
+// e.g. to measure a write-ahead log's sync behavior.
+func WithFsync(every int) Option {
+	return func(opts *options) {
+		opts.Fsync = every
+	}
+}
+
+// WithDataPattern configures the contents of written buffers. By default,
+// probes write incompressible data, refilling buffers with random data on
+// every write; the zero DataPattern has fio fill buffers once and reuse them
+// as they are, neither refilled nor scrambled.
+func WithDataPattern(pattern DataPattern) Option {
+	return func(opts *options) {
+		opts.Data = &pattern
+	}
+}
+
+// WithExtraArgs passes the given arguments, each of the form --key=value or
+// --key, to fio, for options not otherwise modeled (e.g.
+// "--percentile_list=50:99:99.9"). Options the probe already sets can't be
+// overridden this way, nor can conflicting ones be set; see Job. Nor can
+// the ones shaping the probe's disk use and duration, which are derived from
+// its other options (e.g. --size, --numjobs, --runtime).
+func WithExtraArgs(args []string) Option {
+	return func(opts *options) {
+		opts.ExtraArgs = args
+	}
+}
+
+// WithIOEngine configures the fio I/O engine used to issue I/O. It defaults
+// to libaio on Linux and posixaio on darwin; use Capabilities to find out what
+// engines are available on the host.
+func WithIOEngine(engine IOEngine) Option {
+	return func(opts *options) {
+		opts.IOEngine = engine
+	}
+}
+
+// WithIOPriority has fio issue I/O with the given scheduling class and level
+// (0-7, lower is higher priority; ignored for the idle class), e.g. to run
+// probes on production nodes as second-class citizens. I/O priorities are
+// only honored by some I/O schedulers (e.g. BFQ), and on Linux. To bound a
+// probe's impact otherwise, see ExecRunner.Cgroup.
+func WithIOPriority(class IOPriorityClass, level int) Option {
+	return func(opts *options) {
+		opts.IOPriority = IOPriority{Class: class, Level: level}
+	}
+}
+
+// WithCgroupFastPath skips probing if the calling process's cgroups limit the
+// probed kind of I/O to below the given device capacity (in bytes/s or IOPS,
+// e.g. from an earlier probe or the device's specification), returning the
+// cgroup limit instead since that's what a probe would measure. A zero
+// capacity treats any configured limit as the bottleneck.
+func WithCgroupFastPath(capacity uint64) Option {
+	return func(opts *options) {
+		opts.CgroupFastPath = true
+		opts.DeviceCapacity = capacity
+	}
+}
+
+// WithArtifacts has the probe write an artifact bundle to the given path,
+// for rerunning it by hand or handing it to someone else: the equivalent fio
+// job file, the exact command line, fio's raw output (stdout and stderr), a
+// fingerprint of the environment (see Environment) and timing. The bundle is
+// written as a tarball if the path ends in .tar, .tar.gz or .tgz, and to a
+// directory otherwise. It must not be within the probe directory, which is
+// cleared after the probe.
+func WithArtifacts(path string) Option {
+	return func(opts *options) {
+		opts.Artifacts = path
+	}
+}
+
+// WithRunner configures how fio is run. It defaults to executing the fio
+// binary found in $PATH; tests can substitute a fake (see package fiotest).
+func WithRunner(runner Runner) Option {
+	return func(opts *options) {
+		opts.Runner = runner
+	}
+}
+
 // WithLoggingTo instructs the liveness module to log to the given io.Writer.
 func WithLoggingTo(w io.Writer) Option {
 	return func(opts *options) {
@@ -75,18 +255,283 @@ func WithLoggingTo(w io.Writer) Option {
 }
 
 type options struct {
-	Directory string
-	Duration  time.Duration
-	Ramp      time.Duration
-	Size      uint64
-	Kind      Kind
-	MaxRate   uint64
-	LoggingTo io.Writer
+	Directory  string
+	Duration   time.Duration
+	Ramp       time.Duration
+	Size       uint64
+	Reserved   uint64
+	Kind       Kind
+	MaxRate    uint64
+	IOEngine   IOEngine
+	IOPriority IOPriority
+	Runner     Runner
+	LoggingTo  io.Writer
+
+	ReadWrite ReadWrite
+	ReadMix   *int
+	BlockSize uint64
+	IODepth   int
+	Jobs      uint64
+	Buffered  bool
+	Fsync     int
+	Data      *DataPattern
+	ExtraArgs []string
+	Artifacts string
+
+	RandomDistribution RandomDistribution
+	PercentageRandom   *int
+
+	ReadBlockSizes  BlockSizeSplit
+	WriteBlockSizes BlockSizeSplit
+
+	MaxDiskFraction float64
+	CgroupFastPath  bool
+	DeviceCapacity  uint64
 }
 
 func (o *options) validate() error {
+	if o.MaxDiskFraction < 0 || o.MaxDiskFraction > 1 {
+		return fmt.Errorf("invalid disk fraction: %v", o.MaxDiskFraction)
+	}
+	if o.Runner == nil {
+		return fmt.Errorf("probe runner unspecified")
+	}
+	if o.IOEngine == "" {
+		return fmt.Errorf("probe I/O engine unspecified")
+	}
+	if err := o.IOPriority.validate(); err != nil {
+		return err
+	}
+	if o.ReadWrite != "" {
+		switch o.ReadWrite {
+		case SeqRead, SeqWrite, RandRead, RandWrite, SeqReadWrite, RandReadWrite:
+		default:
+			return fmt.Errorf("invalid I/O pattern: %s", o.ReadWrite)
+		}
+	}
+	if o.ReadMix != nil && (*o.ReadMix < 0 || *o.ReadMix > 100) {
+		return fmt.Errorf("invalid read mix: %d%%", *o.ReadMix)
+	}
+	if o.IODepth < 0 {
+		return fmt.Errorf("invalid I/O depth: %d", o.IODepth)
+	}
+	if o.Fsync < 0 {
+		return fmt.Errorf("invalid fsync frequency: %d", o.Fsync)
+	}
+	if o.Data != nil {
+		if err := o.Data.validate(); err != nil {
+			return err
+		}
+	}
+	for _, split := range []BlockSizeSplit{o.ReadBlockSizes, o.WriteBlockSizes} {
+		if split == nil {
+			continue
+		}
+		if err := split.validate(); err != nil {
+			return err
+		}
+	}
+	if err := o.RandomDistribution.validate(); err != nil {
+		return err
+	}
+	if o.PercentageRandom != nil && (*o.PercentageRandom < 0 || *o.PercentageRandom > 100) {
+		return fmt.Errorf("invalid random percentage: %d%%", *o.PercentageRandom)
+	}
+	for _, arg := range o.ExtraArgs {
+		key, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if managed, ok := managedJobOptions[canonicalOption(key)]; ok {
+			return fmt.Errorf("fio option %q can't be passed through, as the probe manages it; use %s instead", key, managed)
+		}
+	}
+	if err := validateArtifacts(o.Directory, o.Artifacts); err != nil {
+		return err
+	}
+	return nil
+}
+
+// managedJobOptions are fio options the probe derives from its own options,
+// keeping to its disk space, reserve and deadline, and so can't be passed
+// through with WithExtraArgs. Each maps to the option that configures it.
+var managedJobOptions = map[string]string{
+	"directory":       "WithDirectory",
+	"filename":        "WithDirectory",
+	"filename_format": "WithDirectory",
+	"size":            "WithSize",
+	"filesize":        "WithSize",
+	"numjobs":         "WithJobs",
+	"runtime":         "WithDuration",
+	"time_based":      "WithDuration",
+	"ramp_time":       "WithRamp",
+}
+
+// bandwidth returns whether the probe measures {read,write} bandwidth (as
+// opposed to IOPS).
+func (o *options) bandwidth() bool {
+	return (o.Kind == ReadBandwidth) || (o.Kind == WriteBandwidth)
+}
+
+// validateKind checks that the probe's kind is specified, and that the I/O
+// pattern issues the kind of I/O the probe measures.
+func (o *options) validateKind() error {
 	if o.Kind == "" {
 		return fmt.Errorf("probe kind unspecified")
 	}
+	switch rw := o.readWrite(); o.Kind {
+	case ReadBandwidth, ReadIOPS:
+		if !rw.reads() {
+			return fmt.Errorf("%s probe with I/O pattern %q, which issues no reads", o.Kind, rw)
+		}
+	case WriteBandwidth, WriteIOPS:
+		if !rw.writes() {
+			return fmt.Errorf("%s probe with I/O pattern %q, which issues no writes", o.Kind, rw)
+		}
+	default:
+		return fmt.Errorf("invalid kind: %s", o.Kind)
+	}
+	if rw := o.readWrite(); !rw.mixed() && o.ReadMix != nil {
+		return fmt.Errorf("read mix configured for unmixed I/O pattern %q", rw)
+	}
+	if rw := o.readWrite(); !rw.random() {
+		if o.RandomDistribution != Uniform {
+			return fmt.Errorf("random distribution configured for sequential I/O pattern %q", rw)
+		}
+		if o.PercentageRandom != nil {
+			return fmt.Errorf("random percentage configured for sequential I/O pattern %q", rw)
+		}
+	}
 	return nil
 }
+
+// validateInterference checks that none of the options shaping Run's
+// workload, which interference probes have their own fixed ones for, were
+// given.
+func (o *options) validateInterference() error {
+	for _, opt := range []struct {
+		name string
+		set  bool
+	}{
+		{"WithKind", o.Kind != ""},
+		{"WithMaxRate", o.MaxRate != 0},
+		{"WithReadWrite", o.ReadWrite != ""},
+		{"WithReadMix", o.ReadMix != nil},
+		{"WithBlockSize", o.BlockSize != 0},
+		{"WithBlockSizeSplit", o.ReadBlockSizes != nil || o.WriteBlockSizes != nil},
+		{"WithIODepth", o.IODepth != 0},
+		{"WithJobs", o.Jobs != 0},
+		{"WithRandomDistribution", o.RandomDistribution != Uniform},
+		{"WithPercentageRandom", o.PercentageRandom != nil},
+		{"WithFsync", o.Fsync != 0},
+		{"WithArtifacts", o.Artifacts != ""},
+		{"WithCgroupFastPath", o.CgroupFastPath},
+	} {
+		if opt.set {
+			return fmt.Errorf("%s doesn't apply to interference probes", opt.name)
+		}
+	}
+	return nil
+}
+
+// numJobs returns the number of fio jobs the probe runs with. Bandwidth probes
+// use multiple parallel streams.
+func (o *options) numJobs() uint64 {
+	if o.Jobs != 0 {
+		return o.Jobs
+	}
+	if o.bandwidth() {
+		return 8
+	}
+	return 1
+}
+
+// blockSize returns the I/O block size the probe uses.
+func (o *options) blockSize() uint64 {
+	if o.BlockSize != 0 {
+		return o.BlockSize
+	}
+	if o.bandwidth() {
+		return 1 << 20 // 1MiB
+	}
+	return 4 << 10 // 4KiB
+}
+
+// blockSizeSplits returns the mix of block sizes reads and writes use, if
+// configured (see WithBlockSizeSplit).
+func (o *options) blockSizeSplits() (reads, writes BlockSizeSplit, ok bool) {
+	if o.ReadBlockSizes == nil && o.WriteBlockSizes == nil {
+		return nil, nil, false
+	}
+	reads, writes = o.ReadBlockSizes, o.WriteBlockSizes
+	if reads == nil {
+		reads = BlockSizeSplit{{Size: o.blockSize(), Percent: 100}}
+	}
+	if writes == nil {
+		writes = BlockSizeSplit{{Size: o.blockSize(), Percent: 100}}
+	}
+	return reads, writes, true
+}
+
+// maxBlockSize returns the largest block size the probe issues I/O with.
+func (o *options) maxBlockSize() uint64 {
+	reads, writes, ok := o.blockSizeSplits()
+	if !ok {
+		return o.blockSize()
+	}
+	max := reads.max()
+	if w := writes.max(); w > max {
+		max = w
+	}
+	return max
+}
+
+// data returns the contents of buffers written with the given pattern:
+// incompressible data, unless configured otherwise. It returns false if
+// there's nothing to configure, i.e. for reads with no configured pattern.
+func (o *options) data(rw ReadWrite) (DataPattern, bool) {
+	var d DataPattern
+	switch {
+	case o.Data != nil:
+		d = *o.Data
+	case rw.writes():
+		d = incompressible
+	default:
+		return DataPattern{}, false
+	}
+	if d.ScrambleBuffers == nil {
+		d.ScrambleBuffers = new(bool) // see Job.DataPattern
+	}
+	return d, true
+}
+
+// ioDepth returns the number of I/O units each job keeps in flight.
+func (o *options) ioDepth() int {
+	if o.IODepth != 0 {
+		return o.IODepth
+	}
+	return ioDepth
+}
+
+// readWrite returns the probe's I/O pattern: sequential I/O for bandwidth
+// probes, random I/O for IOPS probes, unless configured otherwise.
+func (o *options) readWrite() ReadWrite {
+	if o.ReadWrite != "" {
+		return o.ReadWrite
+	}
+	switch o.Kind {
+	case ReadBandwidth:
+		return SeqRead
+	case WriteBandwidth:
+		return SeqWrite
+	case ReadIOPS:
+		return RandRead
+	default:
+		return RandWrite
+	}
+}
+
+// readMix returns the percentage of I/O that are reads, for mixed patterns.
+func (o *options) readMix() int {
+	if o.ReadMix != nil {
+		return *o.ReadMix
+	}
+	return 50
+}
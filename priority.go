@@ -0,0 +1,73 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import "fmt"
+
+// IOPriorityClass is an I/O scheduling class, as used by ionice(1) and
+// ioprio_set(2).
+type IOPriorityClass int
+
+const (
+	// RealtimeClass I/O gets first access to the disk, starving other
+	// classes if need be.
+	RealtimeClass IOPriorityClass = 1
+	// BestEffortClass is the default scheduling class.
+	BestEffortClass IOPriorityClass = 2
+	// IdleClass I/O only gets disk time when no other I/O is pending.
+	IdleClass IOPriorityClass = 3
+)
+
+func (c IOPriorityClass) String() string {
+	switch c {
+	case RealtimeClass:
+		return "realtime"
+	case BestEffortClass:
+		return "best-effort"
+	case IdleClass:
+		return "idle"
+	default:
+		return fmt.Sprintf("class-%d", int(c))
+	}
+}
+
+// IOPriority is the I/O scheduling class and level (0 is the highest, 7 the
+// lowest) fio issues I/O with. The idle class has no levels. The zero value
+// leaves fio's I/O priority unchanged.
+type IOPriority struct {
+	Class IOPriorityClass `json:"class,omitempty"`
+	Level int             `json:"level,omitempty"`
+}
+
+func (p IOPriority) String() string {
+	if p.Class == IdleClass {
+		return p.Class.String()
+	}
+	return fmt.Sprintf("%s/%d", p.Class, p.Level)
+}
+
+func (p IOPriority) validate() error {
+	switch p.Class {
+	case 0:
+		return nil
+	case RealtimeClass, BestEffortClass, IdleClass:
+	default:
+		return fmt.Errorf("invalid I/O priority class: %d", int(p.Class))
+	}
+	if p.Level < 0 || p.Level > 7 {
+		return fmt.Errorf("invalid I/O priority level: %d", p.Level)
+	}
+	return nil
+}
@@ -16,17 +16,13 @@ package probe
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"runtime"
 	"time"
 
-	"github.com/dustin/go-humanize"
 	"github.com/irfansharif/probe/internal"
-	"github.com/shirou/gopsutil/v3/disk"
 )
 
 // Kind of probe; one of {read,write} {bandwidth,IOPS}.
@@ -39,8 +35,21 @@ const (
 	WriteIOPS      Kind = "write_iops"
 )
 
+const (
+	// interruptGracePeriod is how long we wait for an interrupted fio to write
+	// out its results and exit, before killing it.
+	interruptGracePeriod = 10 * time.Second
+	// ioDepth is the number of I/O units each job keeps in flight.
+	ioDepth = 64
+	// deadlineSlack is how much of the context deadline we leave unused when
+	// deriving the probe duration from it, to account for fio laying out
+	// files, starting up and writing out results.
+	deadlineSlack = 5 * time.Second
+)
+
 // Supported returns whether the probe library is supported (thin check for
-// whether 'fio' is installed and accessible).
+// whether 'fio' is installed and accessible). See Capabilities for what the
+// installed fio is able to do.
 func Supported() bool {
 	_, err := exec.LookPath("fio")
 	return err == nil
@@ -54,8 +63,20 @@ func Supported() bool {
 //       increase. And probe down if IO latencies are unacceptable. Looking at
 //       either PSI metrics, or something else.
 
-// Probe disks for their capacity, i.e. {read,write} {bandwidth,IOPS}.
-func Probe(ctx context.Context, opts ...Option) (_ uint64, err error) {
+// Probe disks for their capacity, i.e. {read,write} {bandwidth,IOPS}. It's a
+// shorthand for Run, returning just the measured value.
+func Probe(ctx context.Context, opts ...Option) (uint64, error) {
+	res, err := Run(ctx, opts...)
+	return res.Value, err
+}
+
+// Run probes disks for their capacity, i.e. {read,write} {bandwidth,IOPS},
+// returning the full result.
+//
+// If the context is cancelled mid-probe, fio is interrupted (rather than
+// killed) so it's able to report what it measured so far. The partial result
+// is returned marked as incomplete, alongside the context's error.
+func Run(ctx context.Context, opts ...Option) (_ Result, err error) {
 	// Test {read,write} throughput by performing sequential {read,writes} with
 	// multiple parallel streams (8+), using an I/O block size of 1 MB and an
 	// I/O depth of at least 64.
@@ -83,26 +104,16 @@ func Probe(ctx context.Context, opts ...Option) (_ uint64, err error) {
 	//    --direct=1 --verify=0 --bs=4K --iodepth=64 --rw=randread \
 	//    --group_reporting=1
 
-	o := &options{
-		Duration:  60 * time.Second,
-		Ramp:      2 * time.Second,
-		Size:      10 << 30, // 10 GiB
-		LoggingTo: io.Discard,
-	}
-	for _, opt := range opts {
-		opt(o)
+	o, err := configure(ctx, 1, opts)
+	if err != nil {
+		return Result{}, err
 	}
-	if err := o.validate(); err != nil {
-		return 0, err
+	if err := o.validateKind(); err != nil {
+		return Result{}, err
 	}
 
-	if err := os.RemoveAll(o.Directory); err != nil {
-		// Nuke left-over state, if any. We don't want to accrete storage use
-		// across {failed,} runs.
-		return 0, err
-	}
-	if err := os.MkdirAll(o.Directory, 0755); err != nil {
-		return 0, err
+	if err := resetDirectory(o.Directory); err != nil {
+		return Result{}, err
 	}
 	defer func() {
 		if err2 := os.RemoveAll(o.Directory); err2 != nil {
@@ -114,101 +125,322 @@ func Probe(ctx context.Context, opts ...Option) (_ uint64, err error) {
 		}
 	}()
 
-	var ioengine = "libaio"
-	if runtime.GOOS == "darwin" {
-		ioengine = "posixaio"
-	}
-
-	var args []string
-	args = append(args,
-		"--name", string(o.Kind),
-		"--directory", o.Directory,
-		"--size", fmt.Sprint(o.Size),
-		"--time_based", "--runtime", fmt.Sprintf("%ds", int(o.Duration.Seconds())),
-		"--ramp_time", fmt.Sprintf("%ds", int(o.Ramp.Seconds())),
-		"--ioengine", ioengine,
-		"--direct", "1",
-		"--verify", "0",
-		"--bs", fmt.Sprint(1<<20), /* 1MiB */
-		"--iodepth", "64",
-		"--group_reporting=1",
-		"--output-format", "json",
-	)
-
-	if (o.Kind == ReadBandwidth) || (o.Kind == WriteBandwidth) {
-		args = append(args, "--numjobs", "8")
-		// Limit aggregate disk use across jobs.
-		o.Size /= 8
+	device, ok := o.device()
+	var limits IOLimits
+	if ok {
+		if limits, err = cgroupLimits(device); err != nil {
+			// Not fatal; we just can't tell if we're being throttled.
+			_, _ = fmt.Fprintf(o.LoggingTo, "unable to read cgroup limits for %s: %s\n", device, err)
+		}
+	}
+	if limit := limits.Limit(o.Kind); o.CgroupFastPath && limit != 0 &&
+		(o.DeviceCapacity == 0 || limit < o.DeviceCapacity) {
+		// The cgroup is the bottleneck, so that's all a probe would measure.
+		return Result{
+			Kind:         o.Kind,
+			Value:        limit,
+			Device:       device,
+			CgroupLimits: limits,
+			FromCgroup:   true,
+			Start:        time.Now(),
+		}, nil
+	}
+
+	plan, err := planSpace(o, o.numJobs(), o.maxBlockSize())
+	if err != nil {
+		return Result{}, err
+	}
+
+	fioJob := o.job(plan)
+	args, err := commandLine(fioJob)
+	if err != nil {
+		return Result{}, err
+	}
+
+	run, err := o.execute(ctx, args)
+	var artifacts string
+	if o.Artifacts != "" {
+		// Written regardless of whether fio failed, which is when they're
+		// most useful.
+		bundle := artifactBundle{
+			job: fioJob, args: args, run: run, runErr: err,
+			device: device, limits: limits,
+		}
+		if err := bundle.write(o); err != nil {
+			// Not fatal; the probe itself went fine.
+			_, _ = fmt.Fprintf(o.LoggingTo, "unable to write artifacts to %s: %s\n", o.Artifacts, err)
+		} else {
+			artifacts = o.Artifacts
+		}
+	}
+	if err != nil {
+		return Result{}, err
+	}
+	// With group reporting, there's just the one job; aggregate regardless.
+	job := internal.Aggregate(run.Output.Jobs)
+
+	res := Result{
+		Kind:         o.Kind,
+		Device:       device,
+		CgroupLimits: limits,
+		Config: Config{
+			IOEngine:           o.IOEngine,
+			BlockSize:          o.blockSize(),
+			Jobs:               plan.Jobs,
+			IODepth:            o.ioDepth(),
+			ReadWrite:          o.ReadWrite,
+			RandomDistribution: o.RandomDistribution,
+			PercentageRandom:   o.PercentageRandom,
+			MaxRate:            o.MaxRate,
+			Buffered:           o.Buffered,
+			Fsync:              o.Fsync,
+			IOPriority:         o.IOPriority,
+			ExtraArgs:          o.ExtraArgs,
+			Size:               plan.Footprint(),
+			Duration:           o.Duration,
+		},
+		BytesRead:    uint64(job.Read.IOBytes),
+		BytesWritten: uint64(job.Write.IOBytes),
+		Start:        run.Start,
+		Elapsed:      run.Elapsed,
+		Incomplete:   run.Interrupted,
+		FioVersion:   run.Output.FioVersion,
+		Diagnostics:  run.Diagnostics,
+		Artifacts:    artifacts,
+	}
+	if o.readWrite().mixed() {
+		res.Config.ReadMix = o.readMix()
+	}
+	if data, ok := o.data(o.readWrite()); ok {
+		res.Config.Data = data
+	}
+	if reads, writes, ok := o.blockSizeSplits(); ok {
+		res.Config.ReadBlockSizes, res.Config.WriteBlockSizes = reads, writes
+		res.BlockSizes = blockSizeStatsOf(reads, writes, job)
+	}
+	for _, j := range run.Output.Jobs {
+		res.Jobs = append(res.Jobs, jobStatsOf(j))
 	}
 
 	switch o.Kind {
 	case ReadBandwidth:
-		args = append(args, "--rw", "read")
+		res.Value = uint64(job.Read.BWBytes)
+		res.Latency = latencyOf(job.Read)
 	case WriteBandwidth:
-		args = append(args, "--rw", "write")
+		res.Value = uint64(job.Write.BWBytes)
+		res.Latency = latencyOf(job.Write)
 	case ReadIOPS:
-		args = append(args, "--rw", "randread")
+		res.Value = uint64(job.Read.IOPS)
+		res.Latency = latencyOf(job.Read)
 	case WriteIOPS:
-		args = append(args, "--rw", "randwrite")
+		res.Value = uint64(job.Write.IOPS)
+		res.Latency = latencyOf(job.Write)
 	default:
-		return 0, fmt.Errorf("invalid kind: %s", o.Kind)
+		return Result{}, fmt.Errorf("invalid kind: %s", o.Kind)
+	}
+	if limit := limits.Limit(o.Kind); limit != 0 && res.Value >= limit*9/10 {
+		msg := fmt.Sprintf("measured %d, within 10%% of the cgroup limit of %d; the probe likely measured the limit rather than the device",
+			res.Value, limit)
+		res.Diagnostics = append(res.Diagnostics, Diagnostic{Message: msg})
+		_, _ = fmt.Fprintln(o.LoggingTo, msg)
 	}
+	if res.Incomplete {
+		return res, ctx.Err()
+	}
+	return res, nil
+}
 
-	if (o.Kind == ReadBandwidth) || (o.Kind == WriteBandwidth) {
-		// Use 1MiB block sizes for bandwidth probes.
-		args = append(args, "--bs", fmt.Sprint(1<<20) /* 1MiB */)
-	} else {
-		// Use 4KiB block sizes for IOPS probes.
-		args = append(args, "--bs", fmt.Sprint(4<<10) /* 4KiB */)
+// configure applies the given options over the defaults. If unspecified, the
+// probe duration is derived from the context deadline, fitting the given
+// number of back-to-back fio runs within it.
+func configure(ctx context.Context, runs int, opts []Option) (*options, error) {
+	o := &options{
+		Ramp:      2 * time.Second,
+		Reserved:  5 << 30, // 5 GiB
+		LoggingTo: io.Discard,
+		IOEngine:  defaultIOEngine(),
+		Runner:    ExecRunner{},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.Duration == 0 {
+		o.Duration = 60 * time.Second
+		if deadline, ok := ctx.Deadline(); ok {
+			// Fit the probe within the context deadline, leaving room for
+			// ramp-up and fio's own bookkeeping.
+			o.Duration = (time.Until(deadline)-deadlineSlack)/time.Duration(runs) - o.Ramp
+			if o.Duration < time.Second {
+				return nil, fmt.Errorf("context deadline too close to probe: %s",
+					time.Until(deadline).Round(time.Millisecond))
+			}
+		}
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
 	}
+	return o, nil
+}
+
+// device identifies the block device backing the probe directory. Failing to
+// isn't fatal, and only logged; results are just harder to attribute.
+func (o *options) device() (Device, bool) {
+	device, err := DeviceOf(o.Directory)
+	if err != nil {
+		_, _ = fmt.Fprintf(o.LoggingTo, "unable to identify device for %s: %s\n", o.Directory, err)
+		return Device{}, false
+	}
+	return device, true
+}
+
+// resetDirectory (re-)creates the probe directory, nuking left-over state, if
+// any. We don't want to accrete storage use across {failed,} runs.
+func resetDirectory(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+// DryRun returns the command line Run would execute fio with, given the same
+// options, without running it. The probe directory is left untouched.
+func DryRun(ctx context.Context, opts ...Option) ([]string, error) {
+	o, err := configure(ctx, 1, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.validateKind(); err != nil {
+		return nil, err
+	}
+	plan, err := planSpace(o, o.numJobs(), o.maxBlockSize())
+	if err != nil {
+		return nil, err
+	}
+	args, err := commandLine(o.job(plan))
+	if err != nil {
+		return nil, err
+	}
+	return append([]string{o.fioPath()}, args...), nil
+}
 
+// newJob returns a job with the options common to every job probes run,
+// given the probe's space plan.
+func (o *options) newJob(name string, plan spacePlan) *Job {
+	return NewJob(name).
+		Directory(o.Directory).
+		Size(plan.JobSize).
+		Runtime(o.Duration).
+		RampTime(o.Ramp).
+		IOEngine(o.IOEngine).
+		Direct(!o.Buffered).
+		Set("verify", "0").
+		// Run jobs as threads rather than forked processes, so nothing
+		// outlives the fio process itself (see configureProcess).
+		Flag("thread")
+}
+
+// job returns the job measuring the configured kind of probe.
+func (o *options) job(plan spacePlan) *Job {
+	// Use 1MiB block sizes for bandwidth probes, 4KiB for IOPS probes,
+	// unless configured otherwise.
+	j := o.newJob(string(o.Kind), plan).
+		ReadWrite(o.readWrite()).
+		IODepth(o.ioDepth()).
+		GroupReporting()
+	if reads, writes, ok := o.blockSizeSplits(); ok {
+		j.BlockSizeSplit(reads, writes)
+	} else {
+		j.BlockSize(o.blockSize())
+	}
+	if o.readWrite().mixed() {
+		j.ReadMix(o.readMix())
+	}
+	j.RandomDistribution(o.RandomDistribution)
+	if o.PercentageRandom != nil {
+		j.PercentageRandom(*o.PercentageRandom)
+	}
+	if plan.Jobs > 1 {
+		// Each job lays out its own file; the plan already limits aggregate
+		// disk use across jobs.
+		j.NumJobs(plan.Jobs)
+	}
 	if o.MaxRate != 0 {
-		if (o.Kind == ReadBandwidth) || (o.Kind == WriteBandwidth) {
-			// We want to preserve a max rate across 8 jobs, so divide
-			// accordingly.
-			args = append(args, "--rate", fmt.Sprint(o.MaxRate/8))
+		// We want to preserve a max rate across all jobs, so divide
+		// accordingly. Round up, as a zero rate would leave fio unthrottled.
+		rate := (o.MaxRate + plan.Jobs - 1) / plan.Jobs
+		if o.bandwidth() {
+			j.Rate(rate)
 		} else {
-			args = append(args, "--rate_iops", fmt.Sprint(o.MaxRate))
+			j.RateIOPS(rate)
 		}
 	}
-
-	usage, err := disk.Usage(o.Directory)
-	if err != nil {
-		return 0, err
+	if o.Fsync != 0 {
+		j.Fsync(o.Fsync)
 	}
-	if limit := o.Size + (5 << 30); usage.Free < limit {
-		return 0, fmt.Errorf("insufficient disk space: %s, want %s",
-			humanize.IBytes(usage.Free),
-			humanize.IBytes(limit))
+	if data, ok := o.data(o.readWrite()); ok {
+		j.DataPattern(data)
 	}
+	return j.IOPriority(o.IOPriority).Extra(o.ExtraArgs...)
+}
 
-	cmd := exec.CommandContext(ctx, "fio", args...)
+// fioRun is the outcome of running fio.
+type fioRun struct {
+	Output      internal.Output
+	Diagnostics []Diagnostic
+	Start       time.Time
+	Elapsed     time.Duration
+	// Interrupted is set if fio was cut short by the context being
+	// cancelled, rather than running for as long as configured.
+	Interrupted bool
+	// Stdout and Stderr are what fio wrote out, verbatim.
+	Stdout, Stderr []byte
+}
 
-	if false {
-		// Sometimes useful for debugging.
-		fmt.Println(cmd.String())
+// execute runs fio with the given arguments and decodes its output. If the
+// context is cancelled mid-run, what fio reported until then is returned.
+// What fio wrote out is returned even if it failed.
+func (o *options) execute(ctx context.Context, args []string) (fioRun, error) {
+	start := time.Now()
+	stdout, stderr, runErr := o.Runner.Run(ctx, args)
+	run := fioRun{Start: start, Elapsed: time.Since(start), Stdout: stdout, Stderr: stderr}
+	// The context may well be cancelled after fio finished (e.g. its deadline
+	// expiring right after), in which case runners return no error.
+	run.Interrupted = runErr != nil && ctx.Err() != nil
+	if runErr != nil && ctx.Err() == nil {
+		_, _ = o.LoggingTo.Write(stderr)
+		_, _ = o.LoggingTo.Write(stdout)
+		return run, runErr
 	}
-	output, err := cmd.CombinedOutput()
+
+	doc, residue, err := internal.ExtractJSON(stdout)
 	if err != nil {
-		_, _ = o.LoggingTo.Write(output)
-		return 0, err
+		_, _ = o.LoggingTo.Write(stderr)
+		_, _ = o.LoggingTo.Write(stdout)
+		if ctx.Err() != nil {
+			return run, ctx.Err()
+		}
+		return run, err
+	}
+	if run.Output, err = internal.Decode(doc); err != nil {
+		if ctx.Err() != nil {
+			return run, ctx.Err()
+		}
+		return run, err
 	}
 
-	var fiout internal.Output
-	if err := json.Unmarshal(output, &fiout); err != nil {
-		return 0, err
+	// fio emits warnings on stderr, and occasionally notes on stdout around
+	// the JSON document. Neither is fatal, but both are worth surfacing.
+	for _, line := range append(internal.Lines(stderr), residue...) {
+		run.Diagnostics = append(run.Diagnostics, Diagnostic{Message: line})
+		_, _ = fmt.Fprintln(o.LoggingTo, line)
 	}
+	return run, nil
+}
 
-	switch o.Kind {
-	case ReadBandwidth:
-		return uint64(fiout.Jobs[0].Read.BWBytes), nil
-	case WriteBandwidth:
-		return uint64(fiout.Jobs[0].Write.BWBytes), nil
-	case ReadIOPS:
-		return uint64(fiout.Jobs[0].Read.IOPS), nil
-	case WriteIOPS:
-		return uint64(fiout.Jobs[0].Write.IOPS), nil
-	default:
-		return 0, fmt.Errorf("invalid kind: %s", o.Kind)
+// fioPath returns the path to the fio binary that's run, as far as we know.
+func (o *options) fioPath() string {
+	if r, ok := o.Runner.(ExecRunner); ok && r.Path != "" {
+		return r.Path
 	}
+	return "fio"
 }
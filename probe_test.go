@@ -15,21 +15,47 @@
 package probe_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/fiotest"
 )
 
 func TestSupported(t *testing.T) {
 	t.Logf("supported = %t", probe.Supported())
 }
 
+func TestCapabilities(t *testing.T) {
+	requireFio(t)
+	caps, err := probe.Capabilities(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	best, _ := caps.BestEngine()
+	t.Logf("version = %s, engines = %v, best = %s", caps.Version, caps.Engines, best)
+}
+
+// requireFio skips tests that need a real fio, i.e. ones that actually probe
+// the disk. Everything else runs against fakes; see package fiotest.
+func requireFio(t *testing.T) {
+	if !probe.Supported() {
+		t.Skip("fio not installed")
+	}
+}
+
 var logger = log.New(os.Stdout, "[probe] ", log.Ltime|log.Lmicroseconds|log.Lshortfile|log.Lmsgprefix)
 
 var opts = []probe.Option{
@@ -41,6 +67,7 @@ var opts = []probe.Option{
 }
 
 func TestWriteIOPS(t *testing.T) {
+	requireFio(t)
 	for _, withMax := range []bool{true, false} {
 		t.Run(fmt.Sprintf("with-max=%t", withMax), func(t *testing.T) {
 			ctx := context.Background()
@@ -63,6 +90,7 @@ func TestWriteIOPS(t *testing.T) {
 }
 
 func TestWriteBandwidth(t *testing.T) {
+	requireFio(t)
 	for _, withMax := range []bool{true, false} {
 		t.Run(fmt.Sprintf("with-max=%t", withMax), func(t *testing.T) {
 			ctx := context.Background()
@@ -85,6 +113,7 @@ func TestWriteBandwidth(t *testing.T) {
 }
 
 func TestReadIOPS(t *testing.T) {
+	requireFio(t)
 	for _, withMax := range []bool{true, false} {
 		t.Run(fmt.Sprintf("with-max=%t", withMax), func(t *testing.T) {
 			ctx := context.Background()
@@ -107,6 +136,7 @@ func TestReadIOPS(t *testing.T) {
 }
 
 func TestReadBandwidth(t *testing.T) {
+	requireFio(t)
 	for _, withMax := range []bool{true, false} {
 		t.Run(fmt.Sprintf("with-max=%t", withMax), func(t *testing.T) {
 			ctx := context.Background()
@@ -127,3 +157,587 @@ func TestReadBandwidth(t *testing.T) {
 		})
 	}
 }
+
+// hermeticOpts are options for probes run against fakes, which don't
+// actually need much disk space.
+func hermeticOpts(t *testing.T, runner probe.Runner) []probe.Option {
+	return []probe.Option{
+		probe.WithDirectory(filepath.Join(t.TempDir(), "dir")),
+		probe.WithDuration(10 * time.Second),
+		probe.WithSize(16 << 20 /* 16 MiB */),
+		probe.WithReservedSpace(0),
+		probe.WithRunner(runner),
+	}
+}
+
+func TestFakeArgs(t *testing.T) {
+	for _, tc := range []struct {
+		kind    probe.Kind
+		maxRate uint64
+		expArgs []string
+	}{
+		{probe.ReadBandwidth, 0, []string{"--rw=read", "--numjobs=8", "--bs=1048576", "--size=2097152"}},
+		{probe.WriteBandwidth, 80 << 20, []string{"--rw=write", "--numjobs=8", "--rate=10485760"}},
+		{probe.ReadIOPS, 1000, []string{"--rw=randread", "--bs=4096", "--rate_iops=1000"}},
+		// Rates below the number of jobs round up, rather than to zero
+		// (i.e. unthrottled).
+		{probe.WriteBandwidth, 3, []string{"--numjobs=8", "--rate=1 "}},
+		{probe.WriteIOPS, 0, []string{"--rw=randwrite", "--bs=4096", "--runtime=10s", "--size=16777216"}},
+	} {
+		t.Run(string(tc.kind), func(t *testing.T) {
+			runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{})}
+			opts := append(hermeticOpts(t, runner), probe.WithKind(tc.kind), probe.WithMaxRate(tc.maxRate))
+			if _, err := probe.Run(context.Background(), opts...); err != nil {
+				t.Fatal(err)
+			}
+			calls := runner.Calls()
+			if len(calls) != 1 {
+				t.Fatalf("expected 1 fio invocation, got %d", len(calls))
+			}
+			args := strings.Join(calls[0], " ")
+			for _, exp := range tc.expArgs {
+				if !strings.Contains(args, exp) {
+					t.Errorf("expected %q in args: %s", exp, args)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeIOPriority(t *testing.T) {
+	for _, tc := range []struct {
+		class   probe.IOPriorityClass
+		level   int
+		expArgs string
+		expCfg  string
+	}{
+		{0, 0, "", "libaio/bs=4096/jobs=1/iodepth=64"},
+		{probe.IdleClass, 0, "--prioclass=3", "libaio/bs=4096/jobs=1/iodepth=64/prio=idle"},
+		{probe.BestEffortClass, 7, "--prioclass=2 --prio=7", "libaio/bs=4096/jobs=1/iodepth=64/prio=best-effort/7"},
+	} {
+		runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{})}
+		opts := append(hermeticOpts(t, runner), probe.WithKind(probe.ReadIOPS),
+			probe.WithIOEngine("libaio"), probe.WithIOPriority(tc.class, tc.level))
+		res, err := probe.Run(context.Background(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := strings.Join(runner.Calls()[0], " ")
+		if tc.expArgs == "" && strings.Contains(args, "prio") {
+			t.Errorf("unexpected I/O priority in args: %s", args)
+		} else if !strings.Contains(args, tc.expArgs) {
+			t.Errorf("expected %q in args: %s", tc.expArgs, args)
+		}
+		if tc.class == probe.IdleClass && strings.Contains(args, "--prio=") {
+			t.Errorf("unexpected I/O priority level for idle class: %s", args)
+		}
+		if got := res.Config.String(); got != tc.expCfg {
+			t.Errorf("config = %s, expected %s", got, tc.expCfg)
+		}
+	}
+
+	runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{})}
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.ReadIOPS), probe.WithIOPriority(probe.BestEffortClass, 8))
+	if _, err := probe.Run(context.Background(), opts...); err == nil {
+		t.Error("expected error for invalid I/O priority level")
+	}
+}
+
+func TestFakeBlockSizeSplit(t *testing.T) {
+	// 75% 4KiB and 25% 64KiB reads, and 4KiB writes (the default block size
+	// for IOPS probes).
+	runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{
+		ReadIOPS: 1000, ReadBW: 1000 * (3*4096 + 65536) / 4,
+		WriteIOPS: 400, WriteBW: 400 * 4096,
+	})}
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.ReadIOPS),
+		probe.WithReadWrite(probe.RandReadWrite),
+		probe.WithBlockSizeSplit(probe.BlockSizeSplit{{Size: 4096, Percent: 75}, {Size: 65536, Percent: 25}}, nil))
+	res, err := probe.Run(context.Background(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args := strings.Join(runner.Calls()[0], " ")
+	if !strings.Contains(args, "--bssplit=4096/75:65536/25,4096/100") || strings.Contains(args, "--bs=") {
+		t.Errorf("unexpected block sizes in args: %s", args)
+	}
+	if exp := "/bssplit=4096/75:65536/25,4096/100/"; !strings.Contains(res.Config.String(), exp) {
+		t.Errorf("expected %q in config: %s", exp, res.Config)
+	}
+	exp := []probe.BlockSizeStats{
+		{Size: 4096, ReadIOPS: 750, ReadBandwidth: 750 * 4096, WriteIOPS: 400, WriteBandwidth: 400 * 4096},
+		{Size: 65536, ReadIOPS: 250, ReadBandwidth: 250 * 65536},
+	}
+	if !reflect.DeepEqual(res.BlockSizes, exp) {
+		t.Errorf("block sizes = %+v, expected %+v", res.BlockSizes, exp)
+	}
+
+	for _, split := range []probe.BlockSizeSplit{
+		{},
+		{{Size: 4096, Percent: 50}, {Size: 8192, Percent: 40}},
+		{{Size: 0, Percent: 100}},
+	} {
+		opts := append(hermeticOpts(t, runner), probe.WithKind(probe.ReadIOPS),
+			probe.WithBlockSizeSplit(split, nil))
+		if _, err := probe.Run(context.Background(), opts...); err == nil {
+			t.Errorf("expected error for block size split %s", split)
+		}
+	}
+}
+
+func TestFakeDataPattern(t *testing.T) {
+	scramble := true
+	for _, tc := range []struct {
+		kind    probe.Kind
+		opts    []probe.Option
+		expArgs []string
+		expCfg  string
+	}{
+		// Write probes default to incompressible data; read probes don't
+		// write any.
+		{probe.WriteIOPS, nil, []string{"--refill_buffers", "--scramble_buffers=0"}, "/refill/scramble=0"},
+		{probe.ReadIOPS, nil, nil, ""},
+		{probe.WriteBandwidth, []probe.Option{probe.WithDataPattern(probe.DataPattern{
+			CompressPercentage: 50, DedupePercentage: 10, ScrambleBuffers: &scramble,
+		})}, []string{"--buffer_compress_percentage=50", "--dedupe_percentage=10", "--scramble_buffers=1"},
+			"/compress=50/dedupe=10/scramble=1"},
+		// The zero pattern has fio reuse buffers as they are: not refilled,
+		// and not scrambled either, which fio otherwise does by default.
+		{probe.WriteIOPS, []probe.Option{probe.WithDataPattern(probe.DataPattern{})},
+			[]string{"--scramble_buffers=0"}, "/scramble=0"},
+	} {
+		runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{})}
+		opts := append(hermeticOpts(t, runner), probe.WithKind(tc.kind))
+		res, err := probe.Run(context.Background(), append(opts, tc.opts...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args := strings.Join(runner.Calls()[0], " ")
+		for _, exp := range tc.expArgs {
+			if !strings.Contains(args, exp) {
+				t.Errorf("expected %q in args: %s", exp, args)
+			}
+		}
+		if tc.expArgs == nil && strings.Contains(args, "buffer") {
+			t.Errorf("unexpected data pattern in args: %s", args)
+		}
+		if !strings.Contains(tc.expCfg, "refill") && strings.Contains(args, "--refill_buffers") {
+			t.Errorf("unexpected buffer refills in args: %s", args)
+		}
+		if got := res.Config.Data.String(); got != tc.expCfg {
+			t.Errorf("data pattern = %q, expected %q", got, tc.expCfg)
+		}
+		// The default pattern is left out of descriptions; others aren't.
+		if isDefault := tc.expCfg == "/refill/scramble=0"; strings.Contains(res.Config.String(), tc.expCfg) == isDefault {
+			t.Errorf("unexpected data pattern in config: %s", res.Config)
+		}
+	}
+}
+
+func TestFakeDiskSpace(t *testing.T) {
+	runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{})}
+	run := func(opts ...probe.Option) ([]string, error) {
+		opts = append(append(hermeticOpts(t, runner), probe.WithKind(probe.WriteIOPS)), opts...)
+		if _, err := probe.Run(context.Background(), opts...); err != nil {
+			return nil, err
+		}
+		calls := runner.Calls()
+		return calls[len(calls)-1], nil
+	}
+	argValue := func(args []string, flag string) uint64 {
+		for _, arg := range args {
+			if value, ok := strings.CutPrefix(arg, flag+"="); ok {
+				v, err := strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return v
+			}
+		}
+		t.Fatalf("%s not found in args: %v", flag, args)
+		return 0
+	}
+
+	if _, err := run(probe.WithSize(1 << 62)); err == nil || !strings.Contains(err.Error(), "insufficient") {
+		t.Errorf("expected insufficient disk space error, got %v", err)
+	}
+	if _, err := run(probe.WithSize(1 << 10)); err == nil || !strings.Contains(err.Error(), "too small") {
+		t.Errorf("expected probe size too small error, got %v", err)
+	}
+	if _, err := run(probe.WithMaxDiskFraction(1.5)); err == nil {
+		t.Errorf("expected invalid disk fraction error")
+	}
+
+	// A tiny fraction of free space caps the configured size.
+	args, err := run(probe.WithSize(1<<62), probe.WithMaxDiskFraction(1e-4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := argValue(args, "--size"); size == 0 || size >= 1<<62 {
+		t.Errorf("expected size capped by disk fraction, got %d", size)
+	}
+}
+
+func TestFakeResult(t *testing.T) {
+	stats := fiotest.Stats{
+		ReadBW: 3 << 30, WriteBW: 1 << 30, ReadIOPS: 71493, WriteIOPS: 21484,
+		ReadLatency:  map[float64]time.Duration{50: time.Millisecond, 99: 2 * time.Millisecond},
+		WriteLatency: map[float64]time.Duration{50: 3 * time.Millisecond, 99: 4 * time.Millisecond},
+	}
+	expP99 := map[probe.Kind]time.Duration{
+		probe.ReadBandwidth:  2 * time.Millisecond,
+		probe.WriteBandwidth: 4 * time.Millisecond,
+		probe.ReadIOPS:       2 * time.Millisecond,
+		probe.WriteIOPS:      4 * time.Millisecond,
+	}
+	for kind, exp := range map[probe.Kind]uint64{
+		probe.ReadBandwidth:  3 << 30,
+		probe.WriteBandwidth: 1 << 30,
+		probe.ReadIOPS:       71493,
+		probe.WriteIOPS:      21484,
+	} {
+		runner := &fiotest.Runner{
+			Stdout: fiotest.Output(stats),
+			Stderr: []byte("fio: file hash not empty on exit\n"),
+		}
+		var log bytes.Buffer
+		opts := append(hermeticOpts(t, runner), probe.WithKind(kind), probe.WithLoggingTo(&log))
+		res, err := probe.Run(context.Background(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Value != exp || res.Kind != kind || res.Incomplete || res.FioVersion != "fio-3.30" {
+			t.Errorf("%s: unexpected result: %+v", kind, res)
+		}
+		if res.Latency.P99 != expP99[kind] || res.Latency.Max != expP99[kind] {
+			t.Errorf("%s: unexpected latency: %+v", kind, res.Latency)
+		}
+		if len(res.Diagnostics) != 1 || res.Diagnostics[0].Message != "fio: file hash not empty on exit" {
+			t.Errorf("%s: unexpected diagnostics: %+v", kind, res.Diagnostics)
+		}
+		if len(res.Jobs) != 1 || res.Jobs[0].Name != "fiotest" || res.Jobs[0].Read.Bandwidth != 3<<30 {
+			t.Errorf("%s: unexpected job stats: %+v", kind, res.Jobs)
+		}
+		if !strings.Contains(log.String(), "file hash not empty") {
+			t.Errorf("%s: expected diagnostics to be logged, got %q", kind, log.String())
+		}
+	}
+}
+
+func TestFakeInterference(t *testing.T) {
+	runner := &fiotest.Runner{Stdout: fiotest.Jobs(
+		fiotest.Job{Name: "foreground", Stats: fiotest.Stats{
+			ReadIOPS:    1000,
+			ReadLatency: map[float64]time.Duration{50: 100 * time.Microsecond, 99: 2 * time.Millisecond},
+		}},
+		fiotest.Job{Name: "background", Stats: fiotest.Stats{WriteBW: 90 << 20}},
+	)}
+	opts := append(hermeticOpts(t, runner), probe.WithIOPriority(probe.IdleClass, 0))
+	res, err := probe.Interference(context.Background(), probe.InterferenceConfig{
+		BackgroundRates: []uint64{0, 100 << 20},
+	}, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := runner.Calls()
+	if len(calls) != 2 {
+		t.Fatalf("expected 2 fio invocations, got %d", len(calls))
+	}
+	baseline, loaded := strings.Join(calls[0], " "), strings.Join(calls[1], " ")
+	if strings.Contains(baseline, "background") {
+		t.Errorf("expected no background job in baseline: %s", baseline)
+	}
+	for _, exp := range []string{
+		"--rw=randread --bs=4096 --iodepth=1 --rate_iops=1000",
+		"--rw=write --bs=1048576 --iodepth=64 --rate=104857600 --refill_buffers --scramble_buffers=0 --prioclass=3",
+	} {
+		if !strings.Contains(loaded, exp) {
+			t.Errorf("expected %q in args: %s", exp, loaded)
+		}
+	}
+	if strings.Contains(loaded, "group_reporting") {
+		t.Errorf("unexpected group reporting: %s", loaded)
+	}
+
+	if len(res.Steps) != 2 {
+		t.Fatalf("expected 2 steps, got %+v", res.Steps)
+	}
+	step := res.Steps[1]
+	if step.BackgroundRate != 100<<20 || step.BackgroundBandwidth != 90<<20 || step.ForegroundIOPS != 1000 {
+		t.Errorf("unexpected step: %+v", step)
+	}
+	if step.Foreground.P50 != 100*time.Microsecond || step.Foreground.P99 != 2*time.Millisecond {
+		t.Errorf("unexpected foreground latency: %+v", step.Foreground)
+	}
+
+	if _, err := probe.Interference(context.Background(), probe.InterferenceConfig{}, opts...); err == nil {
+		t.Error("expected error without background rates")
+	}
+	for _, opt := range []probe.Option{
+		probe.WithKind(probe.ReadIOPS),
+		probe.WithBlockSize(8 << 10),
+		probe.WithJobs(4),
+		probe.WithFsync(1),
+	} {
+		if _, err := probe.Interference(context.Background(), probe.InterferenceConfig{
+			BackgroundRates: []uint64{0},
+		}, append(opts, opt)...); err == nil || !strings.Contains(err.Error(), "doesn't apply") {
+			t.Errorf("expected inapplicable option to be rejected, got %v", err)
+		}
+	}
+
+	// Steps measured before fio fails are kept.
+	failing := &failingRunner{Runner: runner, after: len(runner.Calls()) + 1}
+	res, err = probe.Interference(context.Background(), probe.InterferenceConfig{
+		BackgroundRates: []uint64{0, 100 << 20},
+	}, hermeticOpts(t, failing)...)
+	if err == nil || !res.Incomplete || len(res.Steps) != 1 || res.Steps[0].ForegroundIOPS != 1000 {
+		t.Errorf("expected incomplete result with the first step, got %+v (err = %v)", res, err)
+	}
+}
+
+// failingRunner fails every fio invocation after the given number of them.
+type failingRunner struct {
+	*fiotest.Runner
+	after int
+}
+
+func (r *failingRunner) Run(ctx context.Context, args []string) ([]byte, []byte, error) {
+	if len(r.Calls()) >= r.after {
+		return nil, []byte("fio: something went wrong"), errors.New("exit status 1")
+	}
+	return r.Runner.Run(ctx, args)
+}
+
+func TestFakeErrorCleansUp(t *testing.T) {
+	runner := &fiotest.Runner{
+		Stderr: []byte("fio: ioengine libaio not loaded\n"),
+		Err:    errors.New("exit status 1"),
+	}
+	var log bytes.Buffer
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.WriteIOPS), probe.WithLoggingTo(&log))
+	dir := filepath.Join(t.TempDir(), "dir")
+	opts = append(opts, probe.WithDirectory(dir))
+	if err := os.MkdirAll(filepath.Join(dir, "left-over"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := probe.Run(context.Background(), opts...); err == nil || err.Error() != "exit status 1" {
+		t.Fatalf("expected exit error, got %v", err)
+	}
+	if !strings.Contains(log.String(), "libaio not loaded") {
+		t.Errorf("expected stderr to be logged, got %q", log.String())
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be cleaned up, got %v", dir, err)
+	}
+}
+
+func TestFakeCancellation(t *testing.T) {
+	runner := &fiotest.Runner{
+		Stdout: fiotest.Output(fiotest.Stats{WriteIOPS: 1000}),
+		Delay:  time.Minute,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.WriteIOPS))
+	res, err := probe.Run(ctx, opts...)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if !res.Incomplete || res.Value != 1000 || res.Elapsed <= 0 {
+		t.Errorf("expected incomplete partial result, got %+v", res)
+	}
+}
+
+// cancellingRunner cancels the context once fio finishes, like a deadline
+// expiring just after it.
+type cancellingRunner struct {
+	probe.Runner
+	cancel context.CancelFunc
+}
+
+func (r cancellingRunner) Run(ctx context.Context, args []string) ([]byte, []byte, error) {
+	defer r.cancel()
+	return r.Runner.Run(ctx, args)
+}
+
+func TestFakeCancellationAfterFio(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runner := cancellingRunner{
+		Runner: &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{WriteIOPS: 1000})},
+		cancel: cancel,
+	}
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.WriteIOPS))
+	res, err := probe.Run(ctx, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Incomplete || res.Value != 1000 {
+		t.Errorf("expected complete result, as fio ran to completion, got %+v", res)
+	}
+}
+
+func TestFakeDurationFromDeadline(t *testing.T) {
+	runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{})}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	opts := []probe.Option{
+		probe.WithDirectory(filepath.Join(t.TempDir(), "dir")),
+		probe.WithSize(1 << 20),
+		probe.WithRunner(runner),
+		probe.WithKind(probe.ReadIOPS),
+	}
+	if _, err := probe.Run(ctx, opts...); err != nil {
+		t.Fatal(err)
+	}
+	// 30s deadline, less 2s ramp-up and 5s slack.
+	if args := strings.Join(runner.Calls()[0], " "); !strings.Contains(args, "--runtime=22s") {
+		t.Errorf("expected duration derived from deadline, got: %s", args)
+	}
+}
+
+// TestExecRunner runs probes through a real child process, using a stand-in
+// for fio (testdata/fakefio) that's driven by canned output.
+func TestExecRunner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fakefio is a shell script")
+	}
+	fakefio, err := filepath.Abs(filepath.Join("testdata", "fakefio"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	stdout := filepath.Join(tmp, "stdout")
+	stderr := filepath.Join(tmp, "stderr")
+	argsf := filepath.Join(tmp, "args")
+	output := append([]byte("note: a note on stdout\n"), fiotest.Output(fiotest.Stats{ReadIOPS: 42})...)
+	if err := os.WriteFile(stdout, output, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(stderr, []byte("fio: a warning on stderr\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKEFIO_STDOUT", stdout)
+	t.Setenv("FAKEFIO_STDERR", stderr)
+	t.Setenv("FAKEFIO_ARGS", argsf)
+
+	runner := probe.ExecRunner{Path: fakefio}
+	opts := append(hermeticOpts(t, runner), probe.WithKind(probe.ReadIOPS))
+
+	t.Run("success", func(t *testing.T) {
+		res, err := probe.Run(context.Background(), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Value != 42 {
+			t.Errorf("value = %d, expected 42", res.Value)
+		}
+		var msgs []string
+		for _, d := range res.Diagnostics {
+			msgs = append(msgs, d.Message)
+		}
+		if exp := []string{"fio: a warning on stderr", "note: a note on stdout"}; !reflect.DeepEqual(msgs, exp) {
+			t.Errorf("diagnostics = %q, expected %q", msgs, exp)
+		}
+		args, err := os.ReadFile(argsf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(args), "randread") {
+			t.Errorf("expected randread in recorded args: %s", args)
+		}
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		t.Setenv("FAKEFIO_EXIT", "1")
+		if _, err := probe.Run(context.Background(), opts...); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("cgroup", func(t *testing.T) {
+		cgroup := t.TempDir()
+		procs := filepath.Join(cgroup, "cgroup.procs")
+		if err := os.WriteFile(procs, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		runner := probe.ExecRunner{Path: fakefio, Cgroup: cgroup}
+		if _, err := probe.Run(context.Background(), append(opts, probe.WithRunner(runner))...); err != nil {
+			t.Fatal(err)
+		}
+		pid, err := os.ReadFile(procs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := strconv.Atoi(string(pid)); err != nil {
+			t.Errorf("expected fio's PID in cgroup.procs, got %q", pid)
+		}
+
+		runner.Cgroup = filepath.Join(cgroup, "missing")
+		if _, err := probe.Run(context.Background(), append(opts, probe.WithRunner(runner))...); err == nil {
+			t.Error("expected error moving fio into a missing cgroup")
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		t.Setenv("FAKEFIO_SLEEP", "60")
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		res, err := probe.Run(ctx, opts...)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+		if !res.Incomplete || res.Value != 42 {
+			t.Errorf("expected incomplete partial result, got %+v", res)
+		}
+		if res.Elapsed > 10*time.Second {
+			t.Errorf("expected fio to be interrupted promptly, took %s", res.Elapsed)
+		}
+	})
+}
+
+func TestMeasureHeadroom(t *testing.T) {
+	dir := t.TempDir()
+	device, err := probe.DeviceOf(dir)
+	if err != nil || device.Name == "" {
+		t.Skipf("no block device backing %s (%v)", dir, err)
+	}
+	results := []probe.Result{
+		{Kind: probe.ReadBandwidth, Value: 1 << 30, Device: device},
+		{Kind: probe.WriteBandwidth, Value: 1 << 30, Device: device},
+		{Kind: probe.WriteBandwidth, Value: 1, Device: probe.Device{Major: 1}}, // other device
+	}
+	headroom, err := probe.MeasureHeadroom(context.Background(), dir, 100*time.Millisecond, results...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headroom.Capacity) != 2 || headroom.Capacity[probe.WriteBandwidth] != 1<<30 {
+		t.Errorf("unexpected capacity: %v", headroom.Capacity)
+	}
+	for kind, remaining := range headroom.Remaining {
+		if remaining > headroom.Capacity[kind] {
+			t.Errorf("%s: remaining %d exceeds capacity %d", kind, remaining, headroom.Capacity[kind])
+		}
+	}
+	t.Logf("usage = %+v, saturation = %.3f", headroom.Usage, headroom.Saturation)
+
+	if _, err := probe.MeasureHeadroom(context.Background(), dir, time.Millisecond); err == nil {
+		t.Error("expected error without results")
+	}
+}
+
+func TestLatestByKind(t *testing.T) {
+	start := time.Now()
+	latest := probe.LatestByKind(
+		probe.Result{Kind: probe.ReadIOPS, Value: 1, Start: start},
+		probe.Result{Kind: probe.ReadIOPS, Value: 2, Start: start.Add(time.Hour)},
+		probe.Result{Kind: probe.ReadIOPS, Value: 3, Start: start.Add(2 * time.Hour), Incomplete: true},
+		probe.Result{Kind: probe.WriteIOPS, Value: 4, Start: start.Add(-time.Hour)},
+	)
+	if len(latest) != 2 || latest[probe.ReadIOPS].Value != 2 || latest[probe.WriteIOPS].Value != 4 {
+		t.Errorf("unexpected latest results: %+v", latest)
+	}
+}
@@ -0,0 +1,449 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package profile describes probes declaratively, as workload profiles
+// loaded from JSON or YAML files (or from embedded presets), which run
+// through probe.Run like any other probe.
+//
+// A profile looks as follows, with everything but the name and kind being
+// optional:
+//
+//	name: oltp
+//	description: Mixed random reads and writes, like a transactional database.
+//	kind: read_iops
+//	rw: randrw
+//	rwmixread: 70
+//	random_distribution: zipf:1.2  # or pareto:h, normal[:dev], zoned:60/10:...
+//	percentage_random: 100
+//	block_size: 8KiB
+//	bssplit:           # mixes of block sizes, instead of block_size
+//	  reads: 4k/70:64k/30
+//	  writes: 4k/90:1m/10
+//	iodepth: 16
+//	jobs: 4
+//	rate: 20000        # bytes/s for bandwidth probes, IOPS for IOPS probes
+//	duration: 60s
+//	ramp: 2s
+//	size: 10GiB
+//	ioengine: libaio
+//	sync:
+//	  direct: true     # bypass the page cache (O_DIRECT)
+//	  fsync: 0         # fsync after every N writes
+//	data:              # incompressible (refill_buffers) if left out
+//	  compress_percentage: 50
+//	  dedupe_percentage: 0
+//	  refill_buffers: false
+//	  scramble_buffers: false
+package profile
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile describes the shape of a probe. Zero values leave the probe's
+// defaults in place.
+type Profile struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description,omitempty"`
+	Kind        probe.Kind         `json:"kind"`
+	RW          probe.ReadWrite    `json:"rw,omitempty"`
+	ReadMix     *int               `json:"rwmixread,omitempty"`
+	BlockSize   Size               `json:"block_size,omitempty"`
+	BSSplit     BSSplit            `json:"bssplit"`
+	IODepth     int                `json:"iodepth,omitempty"`
+	Jobs        uint64             `json:"jobs,omitempty"`
+	Rate        Size               `json:"rate,omitempty"`
+	Duration    Duration           `json:"duration,omitempty"`
+	Ramp        *Duration          `json:"ramp,omitempty"`
+	Size        Size               `json:"size,omitempty"`
+	IOEngine    probe.IOEngine     `json:"ioengine,omitempty"`
+	Sync        Sync               `json:"sync"`
+	Data        *probe.DataPattern `json:"data,omitempty"`
+
+	RandomDistribution probe.RandomDistribution `json:"random_distribution,omitempty"`
+	PercentageRandom   *int                     `json:"percentage_random,omitempty"`
+}
+
+// Sync describes how a profile's I/O interacts with the page cache and
+// durability.
+type Sync struct {
+	// Direct bypasses the page cache (O_DIRECT). It defaults to true.
+	Direct *bool `json:"direct,omitempty"`
+	// Fsync has each job fsync its file after every given number of writes.
+	Fsync int `json:"fsync,omitempty"`
+}
+
+// BSSplit describes mixes of block sizes for reads and writes, either of which
+// defaults to the block size.
+type BSSplit struct {
+	Reads  BlockSizeSplit `json:"reads,omitempty"`
+	Writes BlockSizeSplit `json:"writes,omitempty"`
+}
+
+//go:embed presets/*.yaml
+var presets embed.FS
+
+// Presets returns the names of the embedded presets.
+func Presets() []string {
+	entries, _ := presets.ReadDir("presets")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Preset returns the embedded preset with the given name.
+func Preset(name string) (Profile, error) {
+	data, err := presets.ReadFile(path.Join("presets", name+".yaml"))
+	if err != nil {
+		return Profile{}, fmt.Errorf("unknown preset %q (have %s)", name, strings.Join(Presets(), ", "))
+	}
+	return Parse(data)
+}
+
+// Load reads the profile in the given file, or if there's no such file, the
+// preset with the given name.
+func Load(nameOrPath string) (Profile, error) {
+	data, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !strings.ContainsAny(nameOrPath, "/.") {
+			return Preset(nameOrPath)
+		}
+		return Profile{}, err
+	}
+	p, err := Parse(data)
+	if err != nil {
+		return Profile{}, fmt.Errorf("%s: %w", nameOrPath, err)
+	}
+	return p, nil
+}
+
+// Parse parses and validates a profile, given as JSON or YAML. Unknown fields
+// are rejected, so typos don't silently go unapplied.
+func Parse(data []byte) (Profile, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || trimmed[0] != '{' {
+		// YAML, which we decode through JSON so there's a single set of
+		// field names and decoding rules.
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return Profile{}, fmt.Errorf("parsing profile: %w", err)
+		}
+		if doc == nil {
+			return Profile{}, fmt.Errorf("parsing profile: empty")
+		}
+		var err error
+		if data, err = json.Marshal(doc); err != nil {
+			return Profile{}, fmt.Errorf("parsing profile: %w", err)
+		}
+	}
+	var p Profile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return Profile{}, fmt.Errorf("parsing profile: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return Profile{}, err
+	}
+	return p, nil
+}
+
+// Validate checks the profile for errors, reporting all of them.
+func (p Profile) Validate() error {
+	var errs []error
+	errorf := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if p.Name == "" {
+		errorf("name unspecified")
+	}
+	var reads, writes bool
+	switch p.Kind {
+	case probe.ReadBandwidth, probe.ReadIOPS:
+		reads = true
+	case probe.WriteBandwidth, probe.WriteIOPS:
+		writes = true
+	case "":
+		errorf("kind unspecified")
+	default:
+		errorf("invalid kind %q: want one of %s, %s, %s or %s", p.Kind,
+			probe.ReadBandwidth, probe.WriteBandwidth, probe.ReadIOPS, probe.WriteIOPS)
+	}
+	mixed := p.RW == probe.SeqReadWrite || p.RW == probe.RandReadWrite
+	switch p.RW {
+	case "", probe.SeqReadWrite, probe.RandReadWrite:
+	case probe.SeqRead, probe.RandRead:
+		if writes {
+			errorf("rw %q issues no writes, for a %s probe", p.RW, p.Kind)
+		}
+		writes = false
+	case probe.SeqWrite, probe.RandWrite:
+		if reads {
+			errorf("rw %q issues no reads, for a %s probe", p.RW, p.Kind)
+		}
+		reads = false
+	default:
+		errorf("invalid rw %q: want one of read, write, randread, randwrite, rw or randrw", p.RW)
+	}
+	if p.ReadMix != nil {
+		if !mixed {
+			errorf("rwmixread only applies to mixed rw (rw or randrw)")
+		} else if *p.ReadMix < 0 || *p.ReadMix > 100 {
+			errorf("invalid rwmixread %d: want a percentage", *p.ReadMix)
+		}
+	}
+	random := p.RW == probe.RandRead || p.RW == probe.RandWrite || p.RW == probe.RandReadWrite ||
+		(p.RW == "" && (p.Kind == probe.ReadIOPS || p.Kind == probe.WriteIOPS))
+	if p.RandomDistribution != "" {
+		if _, err := probe.ParseRandomDistribution(string(p.RandomDistribution)); err != nil {
+			errorf("%s", err)
+		} else if !random {
+			errorf("random_distribution only applies to random rw (randread, randwrite or randrw)")
+		}
+	}
+	if p.PercentageRandom != nil {
+		if !random {
+			errorf("percentage_random only applies to random rw (randread, randwrite or randrw)")
+		} else if *p.PercentageRandom < 0 || *p.PercentageRandom > 100 {
+			errorf("invalid percentage_random %d: want a percentage", *p.PercentageRandom)
+		}
+	}
+	if p.BlockSize != 0 && (p.Sync.Direct == nil || *p.Sync.Direct) && p.BlockSize%512 != 0 {
+		errorf("invalid block_size %d: direct I/O needs a multiple of 512 bytes", p.BlockSize)
+	}
+	for _, split := range []BlockSizeSplit{p.BSSplit.Reads, p.BSSplit.Writes} {
+		for _, share := range split {
+			if (p.Sync.Direct == nil || *p.Sync.Direct) && share.Size%512 != 0 {
+				errorf("invalid bssplit block size %d: direct I/O needs a multiple of 512 bytes", share.Size)
+			}
+		}
+	}
+	if p.IODepth < 0 {
+		errorf("invalid iodepth %d", p.IODepth)
+	}
+	if p.Duration != 0 && p.Duration < Duration(time.Second) {
+		errorf("invalid duration %s: want at least 1s", time.Duration(p.Duration))
+	}
+	if p.Ramp != nil && *p.Ramp < 0 {
+		errorf("invalid ramp %s", time.Duration(*p.Ramp))
+	}
+	if p.Sync.Fsync < 0 {
+		errorf("invalid sync.fsync %d", p.Sync.Fsync)
+	} else if p.Sync.Fsync > 0 && !writes && !mixed {
+		errorf("sync.fsync set, but the profile issues no writes")
+	}
+	if p.Data != nil {
+		if c := p.Data.CompressPercentage; c < 0 || c > 100 {
+			errorf("invalid data.compress_percentage %d: want a percentage", c)
+		}
+		if d := p.Data.DedupePercentage; d < 0 || d > 100 {
+			errorf("invalid data.dedupe_percentage %d: want a percentage", d)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	name := p.Name
+	if name == "" {
+		name = "<unnamed>"
+	}
+	return fmt.Errorf("invalid profile %s: %w", name, errors.Join(errs...))
+}
+
+// Options returns the probe options the profile translates to.
+func (p Profile) Options() []probe.Option {
+	opts := []probe.Option{probe.WithKind(p.Kind)}
+	if p.RW != "" {
+		opts = append(opts, probe.WithReadWrite(p.RW))
+	}
+	if p.ReadMix != nil {
+		opts = append(opts, probe.WithReadMix(*p.ReadMix))
+	}
+	if p.RandomDistribution != "" {
+		d, _ := probe.ParseRandomDistribution(string(p.RandomDistribution))
+		opts = append(opts, probe.WithRandomDistribution(d))
+	}
+	if p.PercentageRandom != nil {
+		opts = append(opts, probe.WithPercentageRandom(*p.PercentageRandom))
+	}
+	if p.BlockSize != 0 {
+		opts = append(opts, probe.WithBlockSize(uint64(p.BlockSize)))
+	}
+	if p.BSSplit.Reads != nil || p.BSSplit.Writes != nil {
+		opts = append(opts, probe.WithBlockSizeSplit(
+			probe.BlockSizeSplit(p.BSSplit.Reads), probe.BlockSizeSplit(p.BSSplit.Writes)))
+	}
+	if p.IODepth != 0 {
+		opts = append(opts, probe.WithIODepth(p.IODepth))
+	}
+	if p.Jobs != 0 {
+		opts = append(opts, probe.WithJobs(p.Jobs))
+	}
+	if p.Rate != 0 {
+		opts = append(opts, probe.WithMaxRate(uint64(p.Rate)))
+	}
+	if p.Duration != 0 {
+		opts = append(opts, probe.WithDuration(time.Duration(p.Duration)))
+	}
+	if p.Ramp != nil {
+		opts = append(opts, probe.WithRamp(time.Duration(*p.Ramp)))
+	}
+	if p.Size != 0 {
+		opts = append(opts, probe.WithSize(uint64(p.Size)))
+	}
+	if p.IOEngine != "" {
+		opts = append(opts, probe.WithIOEngine(p.IOEngine))
+	}
+	if p.Sync.Direct != nil && !*p.Sync.Direct {
+		opts = append(opts, probe.WithBufferedIO())
+	}
+	if p.Sync.Fsync != 0 {
+		opts = append(opts, probe.WithFsync(p.Sync.Fsync))
+	}
+	if p.Data != nil {
+		opts = append(opts, probe.WithDataPattern(*p.Data))
+	}
+	return opts
+}
+
+// Run runs the profile, with the given options (e.g. probe.WithDirectory)
+// applied on top.
+func (p Profile) Run(ctx context.Context, opts ...probe.Option) (probe.Result, error) {
+	return probe.Run(ctx, append(p.Options(), opts...)...)
+}
+
+// Size is a number of bytes, given either as a number or as a string with a
+// unit, e.g. "4k", "4KiB" or "1.5GB". Like fio, units are powers of 1024
+// regardless of how they're spelled.
+type Size uint64
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *Size) UnmarshalJSON(b []byte) error {
+	var n uint64
+	if err := json.Unmarshal(b, &n); err == nil {
+		*s = Size(n)
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("invalid size %s", b)
+	}
+	v, err := parseSize(str)
+	if err != nil {
+		return err
+	}
+	*s = v
+	return nil
+}
+
+func parseSize(str string) (Size, error) {
+	s := strings.ToLower(strings.TrimSpace(str))
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	num, unit := s, ""
+	if i >= 0 {
+		num, unit = s[:i], strings.TrimSpace(s[i:])
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q", str)
+	}
+	var shift int
+	if unit = strings.TrimSuffix(strings.TrimSuffix(unit, "b"), "i"); unit != "" {
+		if shift = strings.Index("kmgtp", unit) + 1; len(unit) != 1 || shift == 0 {
+			return 0, fmt.Errorf("invalid size %q: unknown unit", str)
+		}
+	}
+	return Size(f * float64(uint64(1)<<(10*shift))), nil
+}
+
+// BlockSizeSplit is a weighted mix of block sizes, given in fio's bssplit
+// syntax: colon-separated block sizes, each with the percentage of I/Os using
+// it, e.g. "4k/70:64k/30". Percentages need to add up to 100.
+type BlockSizeSplit probe.BlockSizeSplit
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (s *BlockSizeSplit) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("invalid bssplit %s: want a string, e.g. \"4k/70:64k/30\"", b)
+	}
+	var split BlockSizeSplit
+	var total int
+	for _, part := range strings.Split(str, ":") {
+		size, percent, ok := strings.Cut(part, "/")
+		if !ok {
+			return fmt.Errorf("invalid bssplit %q: want size/percentage, got %q", str, part)
+		}
+		bs, err := parseSize(size)
+		if err != nil {
+			return fmt.Errorf("invalid bssplit %q: %w", str, err)
+		}
+		pct, err := strconv.Atoi(strings.TrimSpace(percent))
+		if err != nil || bs == 0 || pct <= 0 {
+			return fmt.Errorf("invalid bssplit %q: want positive sizes and percentages", str)
+		}
+		split = append(split, probe.BlockSizeShare{Size: uint64(bs), Percent: pct})
+		total += pct
+	}
+	if total != 100 {
+		return fmt.Errorf("invalid bssplit %q: percentages add up to %d, want 100", str, total)
+	}
+	*s = split
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s BlockSizeSplit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(probe.BlockSizeSplit(s).String())
+}
+
+// Duration is a time.Duration given as a string, e.g. "60s".
+type Duration time.Duration
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("invalid duration %s: want a string, e.g. \"60s\"", b)
+	}
+	v, err := time.ParseDuration(str)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", str)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
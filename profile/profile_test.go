@@ -0,0 +1,155 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package profile_test
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/fiotest"
+	"github.com/irfansharif/probe/profile"
+)
+
+func TestPresets(t *testing.T) {
+	names := profile.Presets()
+	if len(names) == 0 {
+		t.Fatal("expected presets")
+	}
+	for _, name := range names {
+		p, err := profile.Preset(name)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if p.Name != name {
+			t.Errorf("preset %s is named %s", name, p.Name)
+		}
+	}
+	if _, err := profile.Preset("nonexistent"); err == nil || !strings.Contains(err.Error(), "oltp") {
+		t.Errorf("expected error listing presets, got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	yaml := `
+name: custom
+kind: write_bandwidth
+rw: randrw
+rwmixread: 30
+block_size: 64k
+iodepth: 8
+jobs: 2
+rate: 100MiB
+duration: 30s
+ramp: 0s
+sync:
+  direct: false
+  fsync: 16
+data:
+  compress_percentage: 50
+`
+	json := `{
+  "name": "custom", "kind": "write_bandwidth", "rw": "randrw", "rwmixread": 30,
+  "block_size": 65536, "iodepth": 8, "jobs": 2, "rate": "100m", "duration": "30s", "ramp": "0s",
+  "sync": {"direct": false, "fsync": 16}, "data": {"compress_percentage": 50}
+}`
+	fromYAML, err := profile.Parse([]byte(yaml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, err := profile.Parse([]byte(json))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML and JSON differ:\n%+v\n%+v", fromYAML, fromJSON)
+	}
+	if fromYAML.BlockSize != 64<<10 || fromYAML.Rate != 100<<20 || time.Duration(fromYAML.Duration) != 30*time.Second {
+		t.Errorf("unexpected profile: %+v", fromYAML)
+	}
+
+	for _, tc := range []struct {
+		profile string
+		errs    []string
+	}{
+		{"name: x\nkind: read_iops\nblocksize: 4k\n", []string{"unknown field \"blocksize\""}},
+		{"name: x\nkind: read_iops\nblock_size: 4 parsecs\n", []string{"unknown unit"}},
+		{"name: x\nkind: read_iops\nduration: 60\n", []string{"invalid duration"}},
+		{"kind: read_iops\nrw: write\nrwmixread: 50\n", []string{
+			"name unspecified", "issues no reads", "rwmixread only applies",
+		}},
+		{"name: x\nkind: fast\nblock_size: 1000\nsync:\n  fsync: 1\n", []string{
+			"invalid kind", "multiple of 512", "issues no writes",
+		}},
+		{"name: x\nkind: read_bandwidth\nrandom_distribution: zipf:1.2\n", []string{
+			"random_distribution only applies",
+		}},
+		{"name: x\nkind: read_iops\nrandom_distribution: zoned:50/50\npercentage_random: 120\n", []string{
+			"add up to 100", "invalid percentage_random",
+		}},
+		{"name: x\nkind: read_iops\nbssplit:\n  reads: 4k/50:1000/40\n", []string{"add up to 90"}},
+		{"name: x\nkind: read_iops\nbssplit:\n  writes: 4k/50:1000/50\n", []string{"multiple of 512"}},
+		{"", []string{"empty"}},
+	} {
+		_, err := profile.Parse([]byte(tc.profile))
+		if err == nil {
+			t.Errorf("expected error parsing %q", tc.profile)
+			continue
+		}
+		for _, exp := range tc.errs {
+			if !strings.Contains(err.Error(), exp) {
+				t.Errorf("expected %q in error: %v", exp, err)
+			}
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	p, err := profile.Load("wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{WriteIOPS: 1200})}
+	res, err := p.Run(context.Background(),
+		probe.WithDirectory(filepath.Join(t.TempDir(), "dir")),
+		probe.WithDuration(10*time.Second),
+		probe.WithSize(16<<20),
+		probe.WithReservedSpace(0),
+		probe.WithRunner(runner),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Value != 1200 || res.Kind != probe.WriteIOPS {
+		t.Errorf("unexpected result: %+v", res)
+	}
+	if exp := "iodepth=1/rw=write/fsync=1"; !strings.Contains(res.Config.String(), exp) {
+		t.Errorf("expected %q in config: %s", exp, res.Config)
+	}
+	args := strings.Join(runner.Calls()[0], " ")
+	for _, exp := range []string{"--rw=write", "--iodepth=1", "--fsync=1", "--bs=4096"} {
+		if !strings.Contains(args, exp) {
+			t.Errorf("expected %q in args: %s", exp, args)
+		}
+	}
+
+	if _, err := profile.Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected error loading missing file")
+	}
+}
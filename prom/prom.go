@@ -0,0 +1,210 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package prom exports probe results and probe activity as Prometheus
+// metrics.
+package prom
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Exporter is a prometheus.Collector for probe results and activity. Register
+// it with a prometheus.Registerer, and record probes using Observe.
+type Exporter struct {
+	bandwidth   *prometheus.GaugeVec
+	iops        *prometheus.GaugeVec
+	latency     *prometheus.GaugeVec
+	cgroupLimit *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
+	runs        *prometheus.CounterVec
+	failures    *prometheus.CounterVec
+	written     *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+}
+
+var _ prometheus.Collector = &Exporter{}
+
+// NewExporter returns a new Exporter.
+func NewExporter() *Exporter {
+	labels := []string{"device", "kind"}
+	return &Exporter{
+		bandwidth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_bandwidth_bytes_per_second",
+			Help: "Bandwidth measured by the latest {read,write} bandwidth probe.",
+		}, labels),
+		iops: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_iops",
+			Help: "I/O operations per second measured by the latest {read,write} IOPS probe.",
+		}, labels),
+		latency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_latency_seconds",
+			Help: "I/O completion latency percentiles observed during the latest probe.",
+		}, append(labels, "percentile")),
+		cgroupLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_cgroup_limit",
+			Help: "cgroup I/O limit reported instead of probing, in bytes/s or IOPS for {read,write} bandwidth and IOPS probes respectively.",
+		}, labels),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "probe_last_success_timestamp_seconds",
+			Help: "Unix timestamp of the latest probe that ran to completion.",
+		}, labels),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "probe_runs_total",
+			Help: "Number of probes run, including failed and incomplete ones.",
+		}, labels),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "probe_failures_total",
+			Help: "Number of probes that failed outright.",
+		}, labels),
+		written: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "probe_written_bytes_total",
+			Help: "Bytes written to disk by probes, including laying out files.",
+		}, labels),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "probe_duration_seconds",
+			Help:    "Wall time taken by probes, including ramp-up.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10), // 1s to ~8.5m
+		}, labels),
+	}
+}
+
+// Observe records the outcome of a probe of the given kind in the given
+// directory, i.e. what probe.Run returned. Incomplete results count as runs
+// but don't update the latest measurements. Neither do results reporting a
+// cgroup limit rather than a measurement (see probe.Result.FromCgroup), which
+// are exported separately.
+func (e *Exporter) Observe(kind probe.Kind, dir string, res probe.Result, err error) {
+	device := res.Device
+	if res.Start.IsZero() {
+		// The probe failed before it got anywhere; identify the device
+		// ourselves (best-effort). The directory's likely gone, as probes
+		// remove it, but it's attributed to its parent's device.
+		device, _ = probe.DeviceOf(dir)
+	}
+	labels := prometheus.Labels{"device": deviceLabel(device), "kind": string(kind)}
+
+	e.runs.With(labels).Inc()
+	if res.Start.IsZero() {
+		e.failures.With(labels).Inc()
+		return
+	}
+	if res.FromCgroup {
+		// Nothing was measured, nor written.
+		if err == nil {
+			e.cgroupLimit.With(labels).Set(float64(res.Value))
+		}
+		return
+	}
+	e.duration.With(labels).Observe(res.Elapsed.Seconds())
+	written := res.BytesWritten
+	if kind == probe.ReadBandwidth || kind == probe.ReadIOPS {
+		// Files read from are first laid out by writing them.
+		written += res.Config.Size
+	}
+	e.written.With(labels).Add(float64(written))
+	if err != nil || res.Incomplete {
+		return
+	}
+
+	switch kind {
+	case probe.ReadBandwidth, probe.WriteBandwidth:
+		e.bandwidth.With(labels).Set(float64(res.Value))
+	default:
+		e.iops.With(labels).Set(float64(res.Value))
+	}
+	// Labeled by percentile rather than quantile, which is reserved for
+	// summaries.
+	for percentile, latency := range map[string]time.Duration{
+		"50":   res.Latency.P50,
+		"99":   res.Latency.P99,
+		"99.9": res.Latency.P999,
+	} {
+		e.latency.MustCurryWith(labels).WithLabelValues(percentile).Set(latency.Seconds())
+	}
+	e.lastSuccess.With(labels).Set(float64(res.Start.Add(res.Elapsed).Unix()))
+}
+
+func (e *Exporter) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		e.bandwidth, e.iops, e.latency, e.cgroupLimit, e.lastSuccess,
+		e.runs, e.failures, e.written, e.duration,
+	}
+}
+
+// Describe implements the prometheus.Collector interface.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range e.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements the prometheus.Collector interface.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range e.collectors() {
+		c.Collect(ch)
+	}
+}
+
+func deviceLabel(d probe.Device) string {
+	if d.Name != "" {
+		return d.Name
+	}
+	if d.Major == 0 && d.Minor == 0 {
+		return "unknown"
+	}
+	return d.MajMin()
+}
+
+// WriteTextfile writes out the metrics gathered from the given gatherer in the
+// format expected by node_exporter's textfile collector, for processes that
+// aren't long-lived enough to be scraped. The file (which should be named
+// *.prom, within the collector's directory) is replaced atomically.
+func WriteTextfile(path string, gatherer prometheus.Gatherer) (err error) {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file in the same directory and rename it into
+	// place, so the collector never reads a partially written file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(tmp, mf); err != nil {
+			return errors.Join(err, tmp.Close())
+		}
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return errors.Join(err, tmp.Close())
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,178 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package prom_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/prom"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExporter(t *testing.T) {
+	exporter := prom.NewExporter()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(exporter)
+
+	device := probe.Device{Major: 259, Minor: 1, Name: "nvme0n1p1"}
+	start := time.Unix(1690585048, 0)
+	exporter.Observe(probe.WriteBandwidth, "dir", probe.Result{
+		Kind:         probe.WriteBandwidth,
+		Value:        1 << 30,
+		Latency:      probe.Latency{P50: time.Millisecond, P99: 4 * time.Millisecond, P999: 8 * time.Millisecond},
+		BytesWritten: 10 << 30,
+		Device:       device,
+		Config:       probe.Config{Size: 5 << 30},
+		Start:        start,
+		Elapsed:      12 * time.Second,
+	}, nil)
+	exporter.Observe(probe.ReadIOPS, "dir", probe.Result{
+		Kind:       probe.ReadIOPS,
+		Value:      100, // partial
+		Device:     device,
+		Config:     probe.Config{Size: 5 << 30},
+		Start:      start,
+		Elapsed:    5 * time.Second,
+		Incomplete: true,
+	}, context.Canceled)
+	exporter.Observe(probe.ReadIOPS, t.TempDir(), probe.Result{}, errors.New("exit status 1"))
+	exporter.Observe(probe.ReadBandwidth, "dir", probe.Result{
+		Kind:       probe.ReadBandwidth,
+		Value:      100 << 20,
+		Device:     device,
+		FromCgroup: true,
+		Start:      start,
+	}, nil)
+
+	expected := `
+# HELP probe_bandwidth_bytes_per_second Bandwidth measured by the latest {read,write} bandwidth probe.
+# TYPE probe_bandwidth_bytes_per_second gauge
+probe_bandwidth_bytes_per_second{device="nvme0n1p1",kind="write_bandwidth"} 1.073741824e+09
+# HELP probe_cgroup_limit cgroup I/O limit reported instead of probing, in bytes/s or IOPS for {read,write} bandwidth and IOPS probes respectively.
+# TYPE probe_cgroup_limit gauge
+probe_cgroup_limit{device="nvme0n1p1",kind="read_bandwidth"} 1.048576e+08
+# HELP probe_iops I/O operations per second measured by the latest {read,write} IOPS probe.
+# TYPE probe_iops gauge
+# HELP probe_latency_seconds I/O completion latency percentiles observed during the latest probe.
+# TYPE probe_latency_seconds gauge
+probe_latency_seconds{device="nvme0n1p1",kind="write_bandwidth",percentile="50"} 0.001
+probe_latency_seconds{device="nvme0n1p1",kind="write_bandwidth",percentile="99"} 0.004
+probe_latency_seconds{device="nvme0n1p1",kind="write_bandwidth",percentile="99.9"} 0.008
+# HELP probe_last_success_timestamp_seconds Unix timestamp of the latest probe that ran to completion.
+# TYPE probe_last_success_timestamp_seconds gauge
+probe_last_success_timestamp_seconds{device="nvme0n1p1",kind="write_bandwidth"} 1.69058506e+09
+# HELP probe_written_bytes_total Bytes written to disk by probes, including laying out files.
+# TYPE probe_written_bytes_total counter
+probe_written_bytes_total{device="nvme0n1p1",kind="read_iops"} 5.36870912e+09
+probe_written_bytes_total{device="nvme0n1p1",kind="write_bandwidth"} 1.073741824e+10
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"probe_bandwidth_bytes_per_second",
+		"probe_cgroup_limit",
+		"probe_iops",
+		"probe_latency_seconds",
+		"probe_last_success_timestamp_seconds",
+		"probe_written_bytes_total",
+	); err != nil {
+		t.Error(err)
+	}
+
+	var runs, failures float64
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range families {
+		for _, m := range mf.GetMetric() {
+			switch mf.GetName() {
+			case "probe_runs_total":
+				runs += m.GetCounter().GetValue()
+			case "probe_failures_total":
+				failures += m.GetCounter().GetValue()
+			}
+		}
+	}
+	if runs != 4 || failures != 1 {
+		t.Errorf("runs = %v, failures = %v; expected 4 and 1", runs, failures)
+	}
+	if n := testutil.CollectAndCount(exporter, "probe_duration_seconds"); n != 2 {
+		t.Errorf("expected 2 duration histograms, got %d", n)
+	}
+}
+
+func TestExporterFailure(t *testing.T) {
+	tmp := t.TempDir()
+	device, err := probe.DeviceOf(tmp)
+	if err != nil || device.Name == "" {
+		t.Skipf("no block device backing %s (%v)", tmp, err)
+	}
+	exporter := prom.NewExporter()
+	reg := prometheus.NewPedanticRegistry()
+	reg.MustRegister(exporter)
+
+	// Probes remove their directory, even when failing.
+	dir := filepath.Join(tmp, "dir")
+	exporter.Observe(probe.WriteIOPS, dir, probe.Result{}, errors.New("exit status 1"))
+
+	expected := fmt.Sprintf(`
+# HELP probe_failures_total Number of probes that failed outright.
+# TYPE probe_failures_total counter
+probe_failures_total{device=%q,kind="write_iops"} 1
+`, device.Name)
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "probe_failures_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteTextfile(t *testing.T) {
+	exporter := prom.NewExporter()
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(exporter)
+	exporter.Observe(probe.WriteIOPS, "dir", probe.Result{
+		Kind:    probe.WriteIOPS,
+		Value:   21484,
+		Device:  probe.Device{Major: 8, Minor: 0, Name: "sda"},
+		Start:   time.Now(),
+		Elapsed: 12 * time.Second,
+	}, nil)
+
+	path := filepath.Join(t.TempDir(), "probe.prom")
+	if err := prom.WriteTextfile(path, reg); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `probe_iops{device="sda",kind="write_iops"} 21484`; !strings.Contains(string(data), exp) {
+		t.Errorf("expected %q in textfile:\n%s", exp, data)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the textfile to remain, got %v", entries)
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// See quotactl(2) and <linux/quota.h>.
+const (
+	qGetQuota   = 0x800007
+	usrQuota    = 0
+	subCmdShift = 8
+	qifBlkSize  = 1 << 10
+)
+
+// ifDqblk mirrors struct if_dqblk.
+type ifDqblk struct {
+	bHardLimit uint64 // in qifBlkSize blocks
+	bSoftLimit uint64
+	curSpace   uint64 // in bytes
+	iHardLimit uint64
+	iSoftLimit uint64
+	curInodes  uint64
+	bTime      uint64
+	iTime      uint64
+	valid      uint32
+}
+
+// userQuota returns what's left of the calling user's quota on the volume
+// backing the given directory, if quotas are enabled and readable.
+func userQuota(dir string) (quota, bool) {
+	device, ok := mountDevice(dir)
+	if !ok {
+		return quota{}, false
+	}
+	devptr, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return quota{}, false
+	}
+	var dq ifDqblk
+	if _, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL,
+		uintptr(qGetQuota<<subCmdShift|usrQuota),
+		uintptr(unsafe.Pointer(devptr)),
+		uintptr(syscall.Getuid()),
+		uintptr(unsafe.Pointer(&dq)), 0, 0); errno != 0 {
+		return quota{}, false // quotas not enabled, or not permitted
+	}
+
+	q := quota{Bytes: ^uint64(0), Inodes: ^uint64(0)}
+	if limit := dq.bHardLimit * qifBlkSize; limit != 0 {
+		q.Bytes = 0
+		if limit > dq.curSpace {
+			q.Bytes = limit - dq.curSpace
+		}
+	}
+	if limit := dq.iHardLimit; limit != 0 {
+		q.Inodes = 0
+		if limit > dq.curInodes {
+			q.Inodes = limit - dq.curInodes
+		}
+	}
+	return q, true
+}
+
+// mountDevice returns the block device backing the given directory, as
+// mounted.
+func mountDevice(dir string) (string, bool) {
+	mount, ok := mountOf(dir)
+	return mount.Device, ok && strings.HasPrefix(mount.Device, "/")
+}
@@ -0,0 +1,302 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/irfansharif/probe/internal"
+)
+
+// Result is the outcome of a single probe attempt.
+type Result struct {
+	// Kind of probe that was run.
+	Kind Kind `json:"kind"`
+	// Value is what was measured: bytes/s for {read,write} bandwidth probes,
+	// and I/O operations/s for {read,write} IOPS probes.
+	Value uint64 `json:"value"`
+	// Latency is the completion latency of I/Os issued during the probe.
+	Latency Latency `json:"latency"`
+	// BytesRead and BytesWritten are how many bytes were transferred while
+	// measuring, i.e. excluding ramp-up and laying out files.
+	BytesRead    uint64 `json:"bytes_read"`
+	BytesWritten uint64 `json:"bytes_written"`
+	// Device is the block device backing the probe directory.
+	Device Device `json:"device"`
+	// CgroupLimits are the I/O limits the calling process's cgroups impose on
+	// the device, if any. See CgroupLimits.
+	CgroupLimits IOLimits `json:"cgroup_limits"`
+	// FromCgroup is set if Value is the cgroup limit for the probe's kind,
+	// returned without probing. See WithCgroupFastPath.
+	FromCgroup bool `json:"from_cgroup,omitempty"`
+	// Config is what the probe was configured with.
+	Config Config `json:"config"`
+	// Start is when the probe started.
+	Start time.Time `json:"start"`
+	// Elapsed is the wall time the probe took, including ramp-up.
+	Elapsed time.Duration `json:"elapsed"`
+	// Incomplete is set if the probe was cut short (i.e. the context was
+	// cancelled while fio ran), in which case Value reflects only what was
+	// measured until then.
+	Incomplete bool `json:"incomplete,omitempty"`
+	// FioVersion is the version of fio that ran the probe, as self-reported in
+	// its output (e.g. "fio-3.30").
+	FioVersion string `json:"fio_version"`
+	// Diagnostics are non-fatal messages fio emitted during the probe.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	// Artifacts is where the probe's artifact bundle was written, if
+	// configured. See WithArtifacts.
+	Artifacts string `json:"artifacts,omitempty"`
+	// BlockSizes break down the headline numbers by block size, when issuing
+	// a mix of them. See WithBlockSizeSplit.
+	BlockSizes []BlockSizeStats `json:"block_sizes,omitempty"`
+	// Jobs are the stats of each fio job (or group of jobs, if reported as
+	// one) that the headline numbers above aggregate.
+	Jobs []JobStats `json:"jobs,omitempty"`
+}
+
+// LatestByKind returns the latest (by start time) complete result of each
+// kind among the given ones.
+func LatestByKind(results ...Result) map[Kind]Result {
+	latest := make(map[Kind]Result)
+	for _, r := range results {
+		if r.Incomplete {
+			continue
+		}
+		if cur, ok := latest[r.Kind]; !ok || r.Start.After(cur.Start) {
+			latest[r.Kind] = r
+		}
+	}
+	return latest
+}
+
+// JobStats are the stats fio reported for a single job.
+type JobStats struct {
+	// Name and GroupID identify the job; jobs run with numjobs > 1 share their
+	// name, and group-reported jobs are reported as one.
+	Name    string `json:"name"`
+	GroupID int    `json:"group_id"`
+	// Elapsed is the job's wall time, including ramp-up.
+	Elapsed time.Duration `json:"elapsed"`
+	Read    IOStats       `json:"read"`
+	Write   IOStats       `json:"write"`
+}
+
+// IOStats summarize a job's reads or writes.
+type IOStats struct {
+	Bytes     uint64  `json:"bytes"`
+	Bandwidth uint64  `json:"bandwidth"` // bytes/s
+	IOPS      uint64  `json:"iops"`
+	Latency   Latency `json:"latency"`
+}
+
+func jobStatsOf(job internal.Job) JobStats {
+	io := func(stats internal.ReadWriteStats) IOStats {
+		return IOStats{
+			Bytes:     uint64(stats.IOBytes),
+			Bandwidth: uint64(stats.BWBytes),
+			IOPS:      uint64(stats.IOPS),
+			Latency:   latencyOf(stats),
+		}
+	}
+	return JobStats{
+		Name:    job.JobName,
+		GroupID: job.GroupID,
+		Elapsed: time.Duration(job.Elapsed * internal.Number(time.Second)),
+		Read:    io(job.Read),
+		Write:   io(job.Write),
+	}
+}
+
+// BlockSizeStats are the part of a probe's I/O of a single block size. fio
+// doesn't report stats by block size, so they're apportioned from the totals
+// by the configured weights: IOPS by the share of I/Os, and bandwidth by the
+// share of bytes. They add up to the totals either way, which with mixed sizes
+// aren't related by any one block size.
+type BlockSizeStats struct {
+	Size           uint64 `json:"size"`
+	ReadIOPS       uint64 `json:"read_iops"`
+	ReadBandwidth  uint64 `json:"read_bandwidth"` // bytes/s
+	WriteIOPS      uint64 `json:"write_iops"`
+	WriteBandwidth uint64 `json:"write_bandwidth"` // bytes/s
+}
+
+func blockSizeStatsOf(reads, writes BlockSizeSplit, job internal.Job) []BlockSizeStats {
+	var stats []BlockSizeStats
+	for _, size := range sizes(reads, writes) {
+		riops, rbw := reads.apportion(size, float64(job.Read.IOPS), float64(job.Read.BWBytes))
+		wiops, wbw := writes.apportion(size, float64(job.Write.IOPS), float64(job.Write.BWBytes))
+		stats = append(stats, BlockSizeStats{
+			Size:           size,
+			ReadIOPS:       uint64(math.Round(riops)),
+			ReadBandwidth:  uint64(math.Round(rbw)),
+			WriteIOPS:      uint64(math.Round(wiops)),
+			WriteBandwidth: uint64(math.Round(wbw)),
+		})
+	}
+	return stats
+}
+
+// Diagnostic is a non-fatal message emitted by fio, e.g. "fio: file hash not
+// empty on exit".
+type Diagnostic struct {
+	Message string `json:"message"`
+}
+
+// Latency summarizes I/O completion latencies, i.e. the time from submission
+// to completion.
+type Latency struct {
+	Mean time.Duration `json:"mean"`
+	P50  time.Duration `json:"p50"`
+	P99  time.Duration `json:"p99"`
+	P999 time.Duration `json:"p999"`
+	Max  time.Duration `json:"max"`
+}
+
+func latencyOf(stats internal.ReadWriteStats) Latency {
+	percentile := func(p float64) time.Duration {
+		v, _ := stats.ClatNS.Percentile(p)
+		return time.Duration(v)
+	}
+	return Latency{
+		Mean: time.Duration(stats.ClatNS.Mean),
+		P50:  percentile(50),
+		P99:  percentile(99),
+		P999: percentile(99.9),
+		Max:  time.Duration(stats.ClatNS.Max),
+	}
+}
+
+// Config is the configuration a probe ran with, as far as it shapes what's
+// being measured.
+type Config struct {
+	IOEngine  IOEngine `json:"ioengine"`
+	BlockSize uint64   `json:"block_size"`
+	// ReadBlockSizes and WriteBlockSizes are the mix of block sizes reads
+	// and writes used, if configured, in which case BlockSize is unused.
+	ReadBlockSizes  BlockSizeSplit `json:"read_block_sizes,omitempty"`
+	WriteBlockSizes BlockSizeSplit `json:"write_block_sizes,omitempty"`
+	Jobs            uint64         `json:"jobs"`
+	IODepth         int            `json:"iodepth"`
+	// ReadWrite is the I/O pattern, if configured rather than following from
+	// the kind of probe; ReadMix is the percentage of reads for mixed
+	// patterns.
+	ReadWrite ReadWrite `json:"rw,omitempty"`
+	ReadMix   int       `json:"rwmixread,omitempty"`
+	MaxRate   uint64    `json:"max_rate,omitempty"`
+	// RandomDistribution is how random I/O offsets were distributed, and
+	// PercentageRandom how much of the I/O was random, if configured.
+	RandomDistribution RandomDistribution `json:"random_distribution,omitempty"`
+	PercentageRandom   *int               `json:"percentage_random,omitempty"`
+	// Buffered is set if I/O went through the page cache.
+	Buffered bool `json:"buffered,omitempty"`
+	// Fsync is how many writes each job issued between fsyncs, if any.
+	Fsync int `json:"fsync,omitempty"`
+	// Data is the contents of written buffers, if any were written.
+	Data DataPattern `json:"data"`
+	// IOPriority is what fio issued I/O with, if configured.
+	IOPriority IOPriority `json:"io_priority"`
+	// ExtraArgs are additional arguments fio was run with.
+	ExtraArgs []string `json:"extra_args,omitempty"`
+	// Size is the number of bytes laid out across all jobs.
+	Size uint64 `json:"size"`
+	// Duration is how long measurements were recorded for.
+	Duration time.Duration `json:"duration"`
+}
+
+// configKeyVersion versions the encoding Key returns. Bump it when changing
+// the encoding such that keys of existing results would change.
+const configKeyVersion = 1
+
+// Key returns a canonical, versioned encoding of the configuration, for
+// telling which results are comparable (e.g. when persisting them; see
+// package history). It's sorted JSON of what shapes the measurement, leaving
+// out size, duration and the default data pattern (like String), and unset
+// fields. Unlike String, it's stable as configuration is extended: results
+// not using newly added fields key the same as before.
+func (c Config) Key() string {
+	c.Size, c.Duration = 0, 0
+	if c.Data.isDefault() {
+		c.Data = DataPattern{}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		panic(err) // Config is plain data
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		panic(err)
+	}
+	delete(fields, "size")
+	delete(fields, "duration")
+	for name, v := range fields {
+		if obj, ok := v.(map[string]interface{}); ok && len(obj) == 0 {
+			delete(fields, name) // e.g. unset I/O priorities
+		}
+	}
+	// Maps are encoded with sorted keys.
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("v%d:%s", configKeyVersion, canonical)
+}
+
+// String returns a compact description of the configuration, leaving out
+// what only affects the precision of a measurement (size, duration) rather
+// than what's measured, and the default data pattern. Results with the same
+// description are comparable.
+func (c Config) String() string {
+	bs := fmt.Sprintf("bs=%d", c.BlockSize)
+	if c.ReadBlockSizes != nil || c.WriteBlockSizes != nil {
+		bs = fmt.Sprintf("bssplit=%s,%s", c.ReadBlockSizes, c.WriteBlockSizes)
+	}
+	s := fmt.Sprintf("%s/%s/jobs=%d/iodepth=%d", c.IOEngine, bs, c.Jobs, c.IODepth)
+	if c.MaxRate != 0 {
+		s += fmt.Sprintf("/rate=%d", c.MaxRate)
+	}
+	if c.ReadWrite != "" {
+		s += fmt.Sprintf("/rw=%s", c.ReadWrite)
+	}
+	if c.ReadWrite.mixed() {
+		s += fmt.Sprintf(":%d", c.ReadMix)
+	}
+	if c.RandomDistribution != Uniform {
+		s += fmt.Sprintf("/dist=%s", c.RandomDistribution)
+	}
+	if c.PercentageRandom != nil {
+		s += fmt.Sprintf("/random=%d%%", *c.PercentageRandom)
+	}
+	if c.Buffered {
+		s += "/buffered"
+	}
+	if c.Fsync != 0 {
+		s += fmt.Sprintf("/fsync=%d", c.Fsync)
+	}
+	if !c.Data.isDefault() {
+		s += c.Data.String()
+	}
+	if len(c.ExtraArgs) != 0 {
+		s += fmt.Sprintf("/extra=%s", strings.Join(c.ExtraArgs, ","))
+	}
+	if c.IOPriority.Class != 0 {
+		s += fmt.Sprintf("/prio=%s", c.IOPriority)
+	}
+	return s
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// Runner runs fio with the given arguments, returning what it wrote to stdout
+// and stderr. If the context is cancelled, implementations are expected to
+// interrupt fio and still return whatever it wrote out, alongside an error
+// (e.g. the context's). If fio finished regardless, no error is returned.
+type Runner interface {
+	Run(ctx context.Context, args []string) (stdout, stderr []byte, err error)
+}
+
+// ExecRunner is a Runner that executes fio as a child process. It's what's
+// used by default.
+type ExecRunner struct {
+	// Path to the fio binary; looked up in $PATH if it contains no path
+	// separators. Defaults to "fio".
+	Path string
+	// Cgroup, if set, is the cgroup v2 directory (e.g.
+	// /sys/fs/cgroup/probe.slice) fio runs in, so its I/O is subject to the
+	// cgroup's controls. On Linux, fio is started directly inside the cgroup
+	// (clone3 with CLONE_INTO_CGROUP). Where that's unavailable (older
+	// kernels, other platforms), fio is instead moved into it right after
+	// it's started, by writing its PID to cgroup.procs; it can then lay out
+	// files or issue some I/O before the move lands, outside the cgroup.
+	Cgroup string
+}
+
+var _ Runner = ExecRunner{}
+
+// Run implements the Runner interface.
+func (r ExecRunner) Run(ctx context.Context, args []string) (stdout, stderr []byte, err error) {
+	var outbuf, errbuf bytes.Buffer
+	cmd := r.command(ctx, args, &outbuf, &errbuf)
+	if r.Cgroup == "" {
+		err = cmd.Start()
+	} else if err = startInCgroup(cmd, r.Cgroup); err != nil {
+		// Fall back to moving fio into the cgroup once started. A failed
+		// command can't be started again, so start afresh.
+		outbuf.Reset()
+		errbuf.Reset()
+		cmd = r.command(ctx, args, &outbuf, &errbuf)
+		if err = cmd.Start(); err == nil {
+			if err := joinCgroup(cmd, r.Cgroup); err != nil {
+				_ = killProcess(cmd)
+				_ = cmd.Wait()
+				return outbuf.Bytes(), errbuf.Bytes(), err
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	err = cmd.Wait()
+	return outbuf.Bytes(), errbuf.Bytes(), err
+}
+
+// command returns the (unstarted) fio command to run.
+func (r ExecRunner) command(ctx context.Context, args []string, stdout, stderr *bytes.Buffer) *exec.Cmd {
+	path := r.Path
+	if path == "" {
+		path = "fio"
+	}
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	configureProcess(cmd)
+	// On cancellation, interrupt fio instead of killing it outright; it then
+	// stops issuing I/O and writes out results for what it's done so far. If
+	// it doesn't exit in time, it gets killed.
+	cmd.Cancel = func() error {
+		if err := interruptProcess(cmd); err != nil {
+			return killProcess(cmd)
+		}
+		return nil
+	}
+	cmd.WaitDelay = interruptGracePeriod
+
+	if false {
+		// Sometimes useful for debugging.
+		fmt.Println(cmd.String())
+	}
+	return cmd
+}
+
+// joinCgroup moves the started fio process into the given cgroup, for when it
+// couldn't be started in it (see startInCgroup). fio runs its jobs as threads
+// (see --thread), which follow the process.
+func joinCgroup(cmd *exec.Cmd, cgroup string) error {
+	procs := filepath.Join(cgroup, "cgroup.procs")
+	f, err := os.OpenFile(procs, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("moving fio into cgroup: %w", err)
+	}
+	if _, err := f.WriteString(strconv.Itoa(cmd.Process.Pid)); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("moving fio into cgroup: writing %s: %w", procs, err)
+	}
+	return f.Close()
+}
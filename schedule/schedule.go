@@ -0,0 +1,269 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package schedule keeps probe results fresh by running probes periodically
+// in the background.
+package schedule
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/irfansharif/probe"
+)
+
+// Target is a directory to probe periodically.
+type Target struct {
+	// Directory to probe in; the underlying device is what's measured.
+	Directory string
+	// Kinds of probes to run.
+	Kinds []probe.Kind
+	// Options are passed through to every probe run against this target.
+	Options []probe.Option
+}
+
+// Window is a daily time-of-day window, e.g. {Start: 22h, End: 6h} for
+// 10PM-6AM. Windows with End before Start wrap around midnight.
+type Window struct {
+	Start, End time.Duration // since midnight
+}
+
+// contains returns whether the given time-of-day falls within the window.
+func (w Window) contains(tod time.Duration) bool {
+	if w.Start <= w.End {
+		return tod >= w.Start && tod < w.End
+	}
+	return tod >= w.Start || tod < w.End
+}
+
+// Event is the outcome of a scheduled probe.
+type Event struct {
+	Target Target
+	Kind   probe.Kind
+	Result probe.Result
+	Err    error
+}
+
+// Config configures a Scheduler.
+type Config struct {
+	// Targets to probe.
+	Targets []Target
+	// Interval is how often each (target, kind) is probed.
+	Interval time.Duration
+	// Jitter randomizes intervals by up to the given fraction (in [0, 1)) in
+	// either direction, so that a fleet started at the same time doesn't
+	// probe in lockstep. It also delays the first probe by up to the given
+	// fraction of the interval.
+	Jitter float64
+	// QuietHours are windows (in Location) during which nothing is probed.
+	QuietHours []Window
+	// Location is the time zone quiet hours are expressed in. Defaults to
+	// time.Local.
+	Location *time.Location
+	// MinSpacing is the minimum time between the end of one probe and the
+	// start of another on the same device, regardless of target and kind.
+	MinSpacing time.Duration
+	// BusyThreshold skips probes if the device is already busy, i.e. if its
+	// utilization (the fraction of time it spent doing I/O, from diskstats)
+	// is at least the given fraction. Zero disables the check.
+	BusyThreshold float64
+	// BusyWindow is how long utilization is sampled for. Defaults to 5s.
+	BusyWindow time.Duration
+	// OnResult, if set, is invoked with the outcome of every probe.
+	OnResult func(Event)
+}
+
+// Scheduler runs probes periodically, one at a time.
+type Scheduler struct {
+	cfg  Config
+	rand *rand.Rand
+
+	// Hooks overridden in tests.
+	now         func() time.Time
+	run         func(ctx context.Context, opts ...probe.Option) (probe.Result, error)
+	deviceOf    func(dir string) (probe.Device, error)
+	utilization func(ctx context.Context, dir string, window time.Duration) (float64, error)
+}
+
+// New returns a new Scheduler with the given configuration.
+func New(cfg Config) (*Scheduler, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("invalid interval: %s", cfg.Interval)
+	}
+	if cfg.Jitter < 0 || cfg.Jitter >= 1 {
+		return nil, fmt.Errorf("invalid jitter: %v", cfg.Jitter)
+	}
+	if cfg.BusyThreshold < 0 || cfg.BusyThreshold > 1 {
+		return nil, fmt.Errorf("invalid busy threshold: %v", cfg.BusyThreshold)
+	}
+	for _, t := range cfg.Targets {
+		if t.Directory == "" || len(t.Kinds) == 0 {
+			return nil, fmt.Errorf("invalid target: %+v", t)
+		}
+	}
+	if cfg.Location == nil {
+		cfg.Location = time.Local
+	}
+	if cfg.BusyWindow == 0 {
+		cfg.BusyWindow = 5 * time.Second
+	}
+	return &Scheduler{
+		cfg:         cfg,
+		rand:        rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:         time.Now,
+		run:         probe.Run,
+		deviceOf:    probe.DeviceOf,
+		utilization: utilization,
+	}, nil
+}
+
+// utilization samples the fraction of time the device backing the given
+// directory spent doing I/O over the given window.
+func utilization(ctx context.Context, dir string, window time.Duration) (float64, error) {
+	usage, err := probe.MeasureUsage(ctx, dir, window)
+	if err != nil {
+		return 0, err
+	}
+	return usage.Utilization, nil
+}
+
+// job is a single (target, kind) being probed periodically.
+type job struct {
+	target Target
+	kind   probe.Kind
+	due    time.Time
+}
+
+// Run runs the scheduler until the context is cancelled, probing one (target,
+// kind) at a time. Probes in flight when the context is cancelled are
+// interrupted. It's not safe to call Run concurrently.
+func (s *Scheduler) Run(ctx context.Context) error {
+	now := s.now()
+	var jobs []*job
+	for _, t := range s.cfg.Targets {
+		for _, k := range t.Kinds {
+			jobs = append(jobs, &job{target: t, kind: k, due: now.Add(s.spread())})
+		}
+	}
+	if len(jobs) == 0 {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	lastProbed := make(map[string]time.Time) // keyed by device ID (or directory)
+	for {
+		next := jobs[0]
+		for _, j := range jobs[1:] {
+			if j.due.Before(next.due) {
+				next = j
+			}
+		}
+		if err := s.sleepUntil(ctx, next.due); err != nil {
+			return err
+		}
+
+		now := s.now()
+		if until, quiet := s.quietUntil(now); quiet {
+			// Spread out probes deferred until the end of quiet hours, like
+			// we do on startup.
+			next.due = until.Add(s.spread())
+			continue
+		}
+
+		device, err := s.deviceOf(next.target.Directory)
+		id := device.ID()
+		if err != nil {
+			// Not fatal; we just can't check for utilization, and space out
+			// probes per directory instead.
+			device, id = probe.Device{}, "dir:"+next.target.Directory
+		}
+		if last, ok := lastProbed[id]; ok && s.cfg.MinSpacing > 0 && now.Sub(last) < s.cfg.MinSpacing {
+			next.due = last.Add(s.cfg.MinSpacing)
+			continue
+		}
+		if s.cfg.BusyThreshold > 0 && device.Name != "" {
+			util, err := s.utilization(ctx, next.target.Directory, s.cfg.BusyWindow)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if err == nil && util >= s.cfg.BusyThreshold {
+				// Back off, trying again a fraction of an interval later.
+				next.due = s.now().Add(s.jitter(s.cfg.Interval / 10))
+				continue
+			}
+		}
+
+		opts := append([]probe.Option{
+			probe.WithDirectory(next.target.Directory),
+			probe.WithKind(next.kind),
+		}, next.target.Options...)
+		res, err := s.run(ctx, opts...)
+		lastProbed[id] = s.now()
+		next.due = s.now().Add(s.jitter(s.cfg.Interval))
+		if s.cfg.OnResult != nil {
+			s.cfg.OnResult(Event{Target: next.target, Kind: next.kind, Result: res, Err: err})
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// spread returns a random delay of up to the configured jitter fraction of an
+// interval.
+func (s *Scheduler) spread() time.Duration {
+	return time.Duration(s.rand.Float64() * s.cfg.Jitter * float64(s.cfg.Interval))
+}
+
+// jitter randomizes the given duration by up to the configured fraction, in
+// either direction.
+func (s *Scheduler) jitter(d time.Duration) time.Duration {
+	return time.Duration(float64(d) * (1 + s.cfg.Jitter*(2*s.rand.Float64()-1)))
+}
+
+// quietUntil returns whether the given time falls within quiet hours, and if
+// so, when they end.
+func (s *Scheduler) quietUntil(now time.Time) (time.Time, bool) {
+	now = now.In(s.cfg.Location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, s.cfg.Location)
+	tod := now.Sub(midnight)
+	for _, w := range s.cfg.QuietHours {
+		if !w.contains(tod) {
+			continue
+		}
+		end := midnight.Add(w.End)
+		if !end.After(now) {
+			end = end.AddDate(0, 0, 1) // wraps around midnight
+		}
+		return end, true
+	}
+	return time.Time{}, false
+}
+
+func (s *Scheduler) sleepUntil(ctx context.Context, t time.Time) error {
+	d := t.Sub(s.now())
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
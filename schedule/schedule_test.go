@@ -0,0 +1,219 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package schedule
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe"
+)
+
+// newTestScheduler returns a scheduler with probes and devices faked out,
+// recording when probes ran.
+func newTestScheduler(t *testing.T, cfg Config) (*Scheduler, func() []time.Time) {
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var ran []time.Time
+	s.run = func(ctx context.Context, opts ...probe.Option) (probe.Result, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, time.Now())
+		return probe.Result{Value: 42}, nil
+	}
+	s.deviceOf = func(dir string) (probe.Device, error) {
+		return probe.Device{Major: 8, Minor: 0, Name: "sda"}, nil
+	}
+	s.utilization = func(context.Context, string, time.Duration) (float64, error) {
+		return 0, nil
+	}
+	return s, func() []time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]time.Time(nil), ran...)
+	}
+}
+
+func runFor(t *testing.T, s *Scheduler, d time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	if err := s.Run(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	var mu sync.Mutex
+	kinds := make(map[probe.Kind]int)
+	s, _ := newTestScheduler(t, Config{
+		Targets: []Target{{
+			Directory: "dir",
+			Kinds:     []probe.Kind{probe.ReadIOPS, probe.WriteIOPS},
+		}},
+		Interval: 20 * time.Millisecond,
+		Jitter:   0.5,
+		OnResult: func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			if e.Err != nil || e.Result.Value != 42 || e.Target.Directory != "dir" {
+				t.Errorf("unexpected event: %+v", e)
+			}
+			kinds[e.Kind]++
+		},
+	})
+	runFor(t, s, 200*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, k := range []probe.Kind{probe.ReadIOPS, probe.WriteIOPS} {
+		if kinds[k] < 2 {
+			t.Errorf("expected %s to be probed repeatedly, got %d", k, kinds[k])
+		}
+	}
+}
+
+func TestSchedulerMinSpacing(t *testing.T) {
+	s, ran := newTestScheduler(t, Config{
+		Targets: []Target{
+			{Directory: "a", Kinds: []probe.Kind{probe.ReadIOPS, probe.WriteIOPS}},
+			{Directory: "b", Kinds: []probe.Kind{probe.ReadBandwidth}}, // same device
+		},
+		Interval:   time.Millisecond,
+		MinSpacing: 50 * time.Millisecond,
+	})
+	runFor(t, s, 300*time.Millisecond)
+
+	times := ran()
+	if len(times) < 2 {
+		t.Fatalf("expected multiple probes, got %d", len(times))
+	}
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap < 50*time.Millisecond {
+			t.Errorf("probes %d and %d only %s apart", i-1, i, gap)
+		}
+	}
+}
+
+func TestSchedulerBusy(t *testing.T) {
+	s, ran := newTestScheduler(t, Config{
+		Targets:       []Target{{Directory: "dir", Kinds: []probe.Kind{probe.ReadIOPS}}},
+		Interval:      10 * time.Millisecond,
+		BusyThreshold: 0.5,
+	})
+	var sampled int
+	s.utilization = func(context.Context, string, time.Duration) (float64, error) {
+		sampled++
+		return 0.9, nil
+	}
+	runFor(t, s, 100*time.Millisecond)
+	if n := len(ran()); n != 0 {
+		t.Errorf("expected no probes while busy, got %d", n)
+	}
+	if sampled < 2 {
+		t.Errorf("expected utilization to be re-sampled, got %d sample(s)", sampled)
+	}
+}
+
+func TestSchedulerDevices(t *testing.T) {
+	// Probe directories only exist while probed, so are attributed to the
+	// device they'd be created on.
+	tmp := t.TempDir()
+	if device, err := probe.DeviceOf(tmp); err != nil || device.Name == "" {
+		t.Skipf("no block device backing %s (%v)", tmp, err)
+	}
+	s, ran := newTestScheduler(t, Config{
+		Targets: []Target{
+			{Directory: filepath.Join(tmp, "a"), Kinds: []probe.Kind{probe.ReadIOPS}},
+			{Directory: filepath.Join(tmp, "b"), Kinds: []probe.Kind{probe.ReadIOPS}},
+		},
+		Interval:      10 * time.Millisecond,
+		BusyThreshold: 0.5,
+	})
+	s.deviceOf = probe.DeviceOf
+	var sampled int
+	s.utilization = func(context.Context, string, time.Duration) (float64, error) {
+		sampled++
+		return 0.9, nil
+	}
+	runFor(t, s, 50*time.Millisecond)
+	if n := len(ran()); n != 0 || sampled == 0 {
+		t.Errorf("expected no probes while busy, got %d (after %d sample(s))", n, sampled)
+	}
+
+	// Directories on unidentified devices are spaced out independently.
+	s, ran = newTestScheduler(t, Config{
+		Targets: []Target{
+			{Directory: "a", Kinds: []probe.Kind{probe.ReadIOPS}},
+			{Directory: "b", Kinds: []probe.Kind{probe.ReadIOPS}},
+		},
+		Interval:   time.Hour,
+		MinSpacing: time.Hour,
+	})
+	s.deviceOf = func(string) (probe.Device, error) {
+		return probe.Device{}, errors.New("unidentified")
+	}
+	runFor(t, s, 50*time.Millisecond)
+	if n := len(ran()); n != 2 {
+		t.Errorf("expected both directories probed, got %d probe(s)", n)
+	}
+}
+
+func TestQuietHours(t *testing.T) {
+	s, _ := newTestScheduler(t, Config{
+		Interval: time.Hour,
+		QuietHours: []Window{
+			{Start: 22 * time.Hour, End: 6 * time.Hour},
+			{Start: 12 * time.Hour, End: 13 * time.Hour},
+		},
+		Location: time.UTC,
+	})
+	day := time.Date(2023, 7, 28, 0, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		now   time.Duration // since midnight
+		quiet bool
+		until time.Time
+	}{
+		{now: 23 * time.Hour, quiet: true, until: day.Add(30 * time.Hour)},
+		{now: 2 * time.Hour, quiet: true, until: day.Add(6 * time.Hour)},
+		{now: 6 * time.Hour, quiet: false},
+		{now: 12*time.Hour + 30*time.Minute, quiet: true, until: day.Add(13 * time.Hour)},
+		{now: 18 * time.Hour, quiet: false},
+	} {
+		until, quiet := s.quietUntil(day.Add(tc.now))
+		if quiet != tc.quiet || !until.Equal(tc.until) {
+			t.Errorf("quietUntil(%s) = %s, %t; expected %s, %t", tc.now, until, quiet, tc.until, tc.quiet)
+		}
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Interval: time.Hour, Jitter: 1},
+		{Interval: time.Hour, BusyThreshold: 2},
+		{Interval: time.Hour, Targets: []Target{{Directory: "dir"}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("expected error for config: %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,469 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package server exposes probes over HTTP, so that orchestration tooling can
+// ask a node what its disks can do.
+//
+// Endpoints:
+//
+//	POST   /probes       start a probe, returning its status (202)
+//	GET    /probes       list probes
+//	GET    /probes/{id}  poll a probe's status, progress and result
+//	DELETE /probes/{id}  cancel a probe
+//	GET    /results      latest complete results, per directory and kind
+//
+// At most one probe runs per device at a time. The server doesn't
+// authenticate requests, and probes saturate disks with writes, so it should
+// only be reachable locally or from behind an authenticating proxy.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/history"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Directories are the ones probes may be run in. Probes clear out their
+	// directory before and after running, so only dedicated directories
+	// should be listed here.
+	Directories []string
+	// Options are applied to every probe, before the ones derived from the
+	// request.
+	Options []probe.Option
+	// Store, if set, is where results are persisted, and where cached
+	// results are looked up.
+	Store *history.Store
+	// RetainedRuns is how many finished probes are kept around to be polled,
+	// after which the oldest are forgotten. Defaults to 100.
+	RetainedRuns int
+}
+
+// Defaults for requests that don't specify a duration or ramp-up.
+const (
+	defaultDuration = 60 * time.Second
+	defaultRamp     = 2 * time.Second
+)
+
+const defaultRetainedRuns = 100
+
+// Request is what's needed to start a probe. Duration and ramp-up default to
+// 60s and 2s respectively, overriding any configured through Config.Options.
+type Request struct {
+	Directory string         `json:"directory"`
+	Kind      probe.Kind     `json:"kind"`
+	Duration  Duration       `json:"duration,omitempty"`
+	Ramp      Duration       `json:"ramp,omitempty"`
+	Size      uint64         `json:"size,omitempty"`
+	MaxRate   uint64         `json:"max_rate,omitempty"`
+	IOEngine  probe.IOEngine `json:"ioengine,omitempty"`
+}
+
+// Duration is a time.Duration that's JSON-encoded as a string, e.g. "10s".
+type Duration time.Duration
+
+// MarshalJSON implements the json.Marshaler interface.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(dur)
+	return nil
+}
+
+// State of a probe.
+type State string
+
+const (
+	Running   State = "running"
+	Done      State = "done"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+)
+
+// Status describes a probe started through the server.
+type Status struct {
+	ID      string    `json:"id"`
+	Request Request   `json:"request"`
+	State   State     `json:"state"`
+	Started time.Time `json:"started"`
+	Elapsed Duration  `json:"elapsed"`
+	// Progress is an estimate of how far along the probe is, in [0, 1].
+	Progress float64       `json:"progress"`
+	Result   *probe.Result `json:"result,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Server is an http.Handler for running probes on demand.
+type Server struct {
+	cfg Config
+	mux *http.ServeMux
+
+	// Overridden in tests.
+	deviceOf func(dir string) (probe.Device, error)
+
+	mu struct {
+		sync.Mutex
+		nextID  int
+		probes  map[string]*run
+		done    []string                               // finished probe IDs, oldest first
+		devices map[string]string                      // device ID -> running probe ID
+		latest  map[string]map[probe.Kind]probe.Result // directory -> kind -> result
+	}
+}
+
+// run is a probe started through the server.
+type run struct {
+	status Status
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ http.Handler = &Server{}
+
+// New returns a new Server.
+func New(cfg Config) *Server {
+	if cfg.RetainedRuns == 0 {
+		cfg.RetainedRuns = defaultRetainedRuns
+	}
+	s := &Server{
+		cfg:      cfg,
+		mux:      http.NewServeMux(),
+		deviceOf: probe.DeviceOf,
+	}
+	s.mu.probes = make(map[string]*run)
+	s.mu.devices = make(map[string]string)
+	s.mu.latest = make(map[string]map[probe.Kind]probe.Result)
+	s.mux.HandleFunc("/probes", s.handleProbes)
+	s.mux.HandleFunc("/probes/", s.handleProbe)
+	s.mux.HandleFunc("/results", s.handleResults)
+	return s
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Close cancels all running probes and waits for them to finish.
+func (s *Server) Close() {
+	s.mu.Lock()
+	var runs []*run
+	for _, r := range s.mu.probes {
+		r.cancel()
+		runs = append(runs, r)
+	}
+	s.mu.Unlock()
+	for _, r := range runs {
+		<-r.done
+	}
+}
+
+func (s *Server) handleProbes(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		statuses := make([]Status, 0, len(s.mu.probes))
+		for _, run := range s.mu.probes {
+			statuses = append(statuses, s.statusLocked(run))
+		}
+		s.mu.Unlock()
+		sort.Slice(statuses, func(i, j int) bool {
+			return statuses[i].Started.Before(statuses[j].Started)
+		})
+		writeJSON(w, http.StatusOK, statuses)
+	case http.MethodPost:
+		var req Request
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		status, code, err := s.start(req)
+		if err != nil {
+			writeError(w, code, err)
+			return
+		}
+		w.Header().Set("Location", "/probes/"+status.ID)
+		writeJSON(w, http.StatusAccepted, status)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (s *Server) handleProbe(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/probes/")
+	s.mu.Lock()
+	run, ok := s.mu.probes[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("probe %q not found", id))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		run.cancel()
+		// Cancelled probes wrap up quickly, writing out partial results.
+		select {
+		case <-run.done:
+		case <-r.Context().Done():
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	s.mu.Lock()
+	status := s.statusLocked(run)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	var maxAge time.Duration
+	if v := r.URL.Query().Get("max_age"); v != "" {
+		var err error
+		if maxAge, err = time.ParseDuration(v); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid max_age: %w", err))
+			return
+		}
+	}
+	dirs := s.cfg.Directories
+	if dir := r.URL.Query().Get("directory"); dir != "" {
+		if !s.allowed(dir) {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("directory %q not allowed", dir))
+			return
+		}
+		dirs = []string{dir}
+	}
+
+	results := []probe.Result{}
+	for _, dir := range dirs {
+		latest, err := s.latest(dir, maxAge)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		results = append(results, latest...)
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+// latest returns the latest complete result for each kind of probe run in the
+// given directory, no older than maxAge (if non-zero).
+func (s *Server) latest(dir string, maxAge time.Duration) ([]probe.Result, error) {
+	byKind := make(map[probe.Kind]probe.Result)
+	s.mu.Lock()
+	for kind, res := range s.mu.latest[dir] {
+		byKind[kind] = res
+	}
+	s.mu.Unlock()
+
+	if s.cfg.Store != nil {
+		// Look for results persisted by earlier incarnations too.
+		device, err := s.deviceOf(dir)
+		if err == nil {
+			keys, err := s.cfg.Store.Keys()
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				if key.Device != device.ID() {
+					continue
+				}
+				res, ok, err := s.cfg.Store.Latest(key, 0)
+				if err != nil {
+					return nil, err
+				}
+				if cur, found := byKind[key.Kind]; ok && (!found || res.Start.After(cur.Start)) {
+					byKind[key.Kind] = res
+				}
+			}
+		}
+	}
+
+	var results []probe.Result
+	for _, res := range byKind {
+		if maxAge != 0 && time.Since(res.Start) > maxAge {
+			continue
+		}
+		results = append(results, res)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Kind < results[j].Kind
+	})
+	return results, nil
+}
+
+// start starts a probe, returning its status or an error alongside the HTTP
+// status code to respond with.
+func (s *Server) start(req Request) (Status, int, error) {
+	if !s.allowed(req.Directory) {
+		return Status{}, http.StatusBadRequest, fmt.Errorf("directory %q not allowed", req.Directory)
+	}
+	switch req.Kind {
+	case probe.ReadBandwidth, probe.WriteBandwidth, probe.ReadIOPS, probe.WriteIOPS:
+	default:
+		return Status{}, http.StatusBadRequest, fmt.Errorf("invalid kind: %q", req.Kind)
+	}
+	device, err := s.deviceOf(req.Directory)
+	if err != nil {
+		// Serialize on the directory instead.
+		device = probe.Device{Name: req.Directory}
+	}
+
+	// Always specify duration and ramp-up explicitly, so we're able to
+	// estimate progress.
+	if req.Duration == 0 {
+		req.Duration = Duration(defaultDuration)
+	}
+	if req.Ramp == 0 {
+		req.Ramp = Duration(defaultRamp)
+	}
+	opts := append([]probe.Option(nil), s.cfg.Options...)
+	opts = append(opts,
+		probe.WithDirectory(req.Directory),
+		probe.WithKind(req.Kind),
+		probe.WithDuration(time.Duration(req.Duration)),
+		probe.WithRamp(time.Duration(req.Ramp)),
+	)
+	if req.Size != 0 {
+		opts = append(opts, probe.WithSize(req.Size))
+	}
+	if req.MaxRate != 0 {
+		opts = append(opts, probe.WithMaxRate(req.MaxRate))
+	}
+	if req.IOEngine != "" {
+		opts = append(opts, probe.WithIOEngine(req.IOEngine))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id, ok := s.mu.devices[device.ID()]; ok {
+		return Status{}, http.StatusConflict, fmt.Errorf("probe %s already running on %s", id, device)
+	}
+	s.mu.nextID++
+	id := strconv.Itoa(s.mu.nextID)
+	ctx, cancel := context.WithCancel(context.Background())
+	run := &run{
+		status: Status{ID: id, Request: req, State: Running, Started: time.Now()},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	s.mu.probes[id] = run
+	s.mu.devices[device.ID()] = id
+
+	go func() {
+		defer close(run.done)
+		defer cancel()
+		res, err := probe.Run(ctx, opts...)
+		if err == nil && s.cfg.Store != nil {
+			if serr := s.cfg.Store.Append(res); serr != nil {
+				err = fmt.Errorf("persisting result: %w", serr)
+			}
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.mu.devices, device.ID())
+		// Forget the oldest finished probes, so polling servers don't grow
+		// without bound.
+		s.mu.done = append(s.mu.done, id)
+		for len(s.mu.done) > s.cfg.RetainedRuns {
+			delete(s.mu.probes, s.mu.done[0])
+			s.mu.done = s.mu.done[1:]
+		}
+		run.status.Elapsed = Duration(time.Since(run.status.Started))
+		switch {
+		case res.Incomplete:
+			run.status.State, run.status.Result = Cancelled, &res
+		case ctx.Err() != nil:
+			run.status.State = Cancelled
+		case err != nil:
+			run.status.State, run.status.Error = Failed, err.Error()
+		default:
+			run.status.State, run.status.Result = Done, &res
+			if s.mu.latest[req.Directory] == nil {
+				s.mu.latest[req.Directory] = make(map[probe.Kind]probe.Result)
+			}
+			s.mu.latest[req.Directory][req.Kind] = res
+		}
+	}()
+	return s.statusLocked(run), http.StatusAccepted, nil
+}
+
+func (s *Server) statusLocked(r *run) Status {
+	status := r.status
+	if status.State != Running {
+		status.Progress = 1
+		return status
+	}
+	status.Elapsed = Duration(time.Since(status.Started))
+	expected := time.Duration(status.Request.Ramp) + time.Duration(status.Request.Duration)
+	status.Progress = float64(status.Elapsed) / float64(expected)
+	if status.Progress > 0.99 {
+		status.Progress = 0.99 // still wrapping up
+	}
+	return status
+}
+
+func (s *Server) allowed(dir string) bool {
+	for _, d := range s.cfg.Directories {
+		if d == dir {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
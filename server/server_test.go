@@ -0,0 +1,196 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/irfansharif/probe"
+	"github.com/irfansharif/probe/fiotest"
+	"github.com/irfansharif/probe/history"
+)
+
+func TestServer(t *testing.T) {
+	tmp := t.TempDir()
+	dirA, dirB := filepath.Join(tmp, "a"), filepath.Join(tmp, "b")
+	store, err := history.Open(filepath.Join(tmp, "history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &fiotest.Runner{
+		Stdout: fiotest.Output(fiotest.Stats{ReadIOPS: 71493}),
+		Delay:  time.Minute,
+	}
+	srv := New(Config{
+		Directories: []string{dirA, dirB},
+		Options: []probe.Option{
+			probe.WithRunner(runner),
+			probe.WithSize(16 << 20),
+			probe.WithReservedSpace(0),
+		},
+		Store: store,
+	})
+	// Both directories are on the same device, but only exist while probed.
+	if _, err := probe.DeviceOf(tmp); err != nil {
+		t.Skip(err)
+	}
+	defer srv.Close()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	do := func(method, path string, body interface{}, expCode int, out interface{}) {
+		t.Helper()
+		var buf bytes.Buffer
+		if body != nil {
+			if err := json.NewEncoder(&buf).Encode(body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		req, err := http.NewRequest(method, ts.URL+path, &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+		if resp.StatusCode != expCode {
+			var e map[string]string
+			_ = json.NewDecoder(resp.Body).Decode(&e)
+			t.Fatalf("%s %s: got %d (%s), expected %d", method, path, resp.StatusCode, e["error"], expCode)
+		}
+		if out != nil {
+			if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Disallowed directories and invalid kinds are rejected.
+	do("POST", "/probes", Request{Directory: "/", Kind: probe.ReadIOPS}, http.StatusBadRequest, nil)
+	do("POST", "/probes", Request{Directory: dirA, Kind: "banana"}, http.StatusBadRequest, nil)
+
+	// Start a probe, and poll it.
+	var status Status
+	do("POST", "/probes", Request{Directory: dirA, Kind: probe.ReadIOPS, Duration: Duration(10 * time.Second)}, http.StatusAccepted, &status)
+	if status.State != Running || status.ID == "" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	do("GET", "/probes/"+status.ID, nil, http.StatusOK, &status)
+	if status.State != Running || status.Progress >= 1 || time.Duration(status.Request.Ramp) != 2*time.Second {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// Only one probe runs per device at a time.
+	do("POST", "/probes", Request{Directory: dirB, Kind: probe.ReadIOPS}, http.StatusConflict, nil)
+
+	// Cancelling returns the partial result.
+	do("DELETE", "/probes/"+status.ID, nil, http.StatusOK, &status)
+	if status.State != Cancelled || status.Result == nil || !status.Result.Incomplete {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	var results []probe.Result
+	do("GET", "/results", nil, http.StatusOK, &results)
+	if len(results) != 0 {
+		t.Fatalf("expected no (complete) results, got %+v", results)
+	}
+
+	// Let the next probe run to completion.
+	runner.Delay = 0
+	do("POST", "/probes", Request{Directory: dirB, Kind: probe.ReadIOPS}, http.StatusAccepted, &status)
+	deadline := time.Now().Add(10 * time.Second)
+	for status.State == Running && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		do("GET", "/probes/"+status.ID, nil, http.StatusOK, &status)
+	}
+	if status.State != Done || status.Result == nil || status.Result.Value != 71493 || status.Progress != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	do("GET", "/results?directory="+dirB, nil, http.StatusOK, &results)
+	if len(results) != 1 || results[0].Value != 71493 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	// The result was persisted, and is found for other directories on the
+	// same device.
+	do("GET", "/results?directory="+dirA, nil, http.StatusOK, &results)
+	if len(results) != 1 || results[0].Value != 71493 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	do("GET", "/results?max_age=1ns", nil, http.StatusOK, &results)
+	if len(results) != 0 {
+		t.Fatalf("expected no results that recent, got %+v", results)
+	}
+
+	// Probes still serialize on the device once their directories are gone.
+	runner.Delay = time.Minute
+	do("POST", "/probes", Request{Directory: dirB, Kind: probe.ReadIOPS}, http.StatusAccepted, &status)
+	do("POST", "/probes", Request{Directory: dirA, Kind: probe.ReadIOPS}, http.StatusConflict, nil)
+	do("DELETE", "/probes/"+status.ID, nil, http.StatusOK, &status)
+
+	var statuses []Status
+	do("GET", "/probes", nil, http.StatusOK, &statuses)
+	if len(statuses) != 3 {
+		t.Fatalf("expected 3 probes, got %d", len(statuses))
+	}
+	do("GET", fmt.Sprintf("/probes/%d", 42), nil, http.StatusNotFound, nil)
+}
+
+func TestRetainedRuns(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	srv := New(Config{
+		Directories: []string{dir},
+		Options: []probe.Option{
+			probe.WithRunner(&fiotest.Runner{Stdout: fiotest.Output(fiotest.Stats{ReadIOPS: 1})}),
+			probe.WithSize(16 << 20),
+			probe.WithReservedSpace(0),
+		},
+		RetainedRuns: 2,
+	})
+	srv.deviceOf = func(string) (probe.Device, error) {
+		return probe.Device{Name: "fake"}, nil
+	}
+	defer srv.Close()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		status, code, err := srv.start(Request{Directory: dir, Kind: probe.ReadIOPS, Duration: Duration(time.Second)})
+		if err != nil {
+			t.Fatalf("%d: %s", code, err)
+		}
+		srv.mu.Lock()
+		run := srv.mu.probes[status.ID]
+		srv.mu.Unlock()
+		<-run.done
+		ids = append(ids, status.ID)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.mu.probes) != 2 {
+		t.Errorf("expected 2 retained probes, got %d", len(srv.mu.probes))
+	}
+	if _, ok := srv.mu.probes[ids[0]]; ok {
+		t.Errorf("expected oldest probe %s to be forgotten", ids[0])
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/dustin/go-humanize"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// defaultSize is how many bytes probes lay out on disk, unless configured
+// otherwise.
+const defaultSize = 10 << 30 // 10 GiB
+
+// spacePlan is the on-disk footprint of a probe.
+type spacePlan struct {
+	// Jobs is the number of fio jobs, each of which lays out its own file.
+	Jobs uint64
+	// JobSize is the size of each job's file, i.e. fio's --size.
+	JobSize uint64
+}
+
+// Footprint is the number of bytes laid out across all jobs.
+func (p spacePlan) Footprint() uint64 {
+	return p.Jobs * p.JobSize
+}
+
+// planSpace sizes the probe, laying out a file for each of the given number of
+// jobs, each of which issues I/O of the given block size. It checks that the
+// probe's footprint fits within what's available to us on the underlying
+// volume, leaving the configured reserve untouched.
+func planSpace(o *options, jobs, blockSize uint64) (spacePlan, error) {
+	// NB: Free here is statfs' f_bavail, i.e. it excludes blocks reserved for
+	// root. Volumes with XFS/ext4 project quotas configured for the directory
+	// report the quota here too.
+	// The directory might not exist yet, as for dry runs; what matters is
+	// the volume it'd be on.
+	dir := o.Directory
+	for {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	usage, err := disk.Usage(dir)
+	if err != nil {
+		return spacePlan{}, err
+	}
+	avail, inodes, source := usage.Free, usage.InodesFree, "disk space"
+	if usage.InodesTotal == 0 {
+		inodes = ^uint64(0) // dynamically allocated inodes, e.g. btrfs
+	}
+	if q, ok := userQuota(dir); ok {
+		if q.Bytes < avail {
+			avail, source = q.Bytes, "quota"
+		}
+		if q.Inodes < inodes {
+			inodes = q.Inodes
+		}
+	}
+
+	size := o.Size
+	if o.MaxDiskFraction != 0 {
+		var usable uint64
+		if avail > o.Reserved {
+			usable = avail - o.Reserved
+		}
+		if auto := uint64(o.MaxDiskFraction * float64(usable)); size == 0 || auto < size {
+			size = auto
+		}
+	}
+	if size == 0 {
+		size = defaultSize
+	}
+
+	plan := spacePlan{Jobs: jobs}
+	plan.JobSize = size / plan.Jobs
+	if bs := blockSize; plan.JobSize < bs {
+		return spacePlan{}, fmt.Errorf("probe size too small: %s across %d job(s), want at least %s per job",
+			humanize.IBytes(size), plan.Jobs, humanize.IBytes(bs))
+	}
+	if want := plan.Footprint() + o.Reserved; avail < want {
+		return spacePlan{}, fmt.Errorf("insufficient %s: %s, want %s (%s across %d job(s), %s reserved)",
+			source,
+			humanize.IBytes(avail),
+			humanize.IBytes(want),
+			humanize.IBytes(plan.Footprint()), plan.Jobs,
+			humanize.IBytes(o.Reserved))
+	}
+	if inodes < plan.Jobs {
+		return spacePlan{}, fmt.Errorf("insufficient inodes: %d, want %d", inodes, plan.Jobs)
+	}
+	return plan, nil
+}
+
+// quota is what's left of a disk quota.
+type quota struct {
+	Bytes, Inodes uint64
+}
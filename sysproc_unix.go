@@ -0,0 +1,47 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+//go:build unix && !linux
+
+package probe
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// configureProcess has fio run in its own process group, so that signals
+// reach every fio worker. There's no equivalent of Linux's Pdeathsig here, so
+// fio outlives us if we crash.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid: true,
+	}
+}
+
+// interruptProcess interrupts fio and all its workers.
+func interruptProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+}
+
+// killProcess kills fio and all its workers.
+func killProcess(cmd *exec.Cmd) error {
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// startInCgroup is only implemented on Linux.
+func startInCgroup(cmd *exec.Cmd, cgroup string) error {
+	return errors.New("starting processes in cgroups is unsupported")
+}
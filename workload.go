@@ -0,0 +1,212 @@
+// Copyright 2023 Irfan Sharif.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package probe
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ReadWrite is the I/O pattern a probe issues, i.e. fio's rw option.
+type ReadWrite string
+
+const (
+	SeqRead       ReadWrite = "read"
+	SeqWrite      ReadWrite = "write"
+	RandRead      ReadWrite = "randread"
+	RandWrite     ReadWrite = "randwrite"
+	SeqReadWrite  ReadWrite = "rw"
+	RandReadWrite ReadWrite = "randrw"
+)
+
+// reads and writes return whether the pattern issues reads and writes
+// respectively.
+func (rw ReadWrite) reads() bool {
+	return rw == SeqRead || rw == RandRead || rw.mixed()
+}
+
+func (rw ReadWrite) writes() bool {
+	return rw == SeqWrite || rw == RandWrite || rw.mixed()
+}
+
+// mixed returns whether the pattern issues both reads and writes, in the
+// proportion given by the read mix.
+func (rw ReadWrite) mixed() bool {
+	return rw == SeqReadWrite || rw == RandReadWrite
+}
+
+// random returns whether the pattern issues I/O at random offsets.
+func (rw ReadWrite) random() bool {
+	return rw == RandRead || rw == RandWrite || rw == RandReadWrite
+}
+
+// BlockSizeSplit is a weighted mix of block sizes, i.e. fio's bssplit: each
+// size is used for the given percentage of I/Os, e.g. a histogram of I/O sizes
+// captured from production.
+type BlockSizeSplit []BlockSizeShare
+
+// BlockSizeShare is a block size and the percentage of I/Os using it.
+type BlockSizeShare struct {
+	Size    uint64 `json:"size"`
+	Percent int    `json:"percent"`
+}
+
+// String returns the split in fio's syntax, e.g. "4096/50:65536/50".
+func (s BlockSizeSplit) String() string {
+	parts := make([]string, len(s))
+	for i, share := range s {
+		parts[i] = fmt.Sprintf("%d/%d", share.Size, share.Percent)
+	}
+	return strings.Join(parts, ":")
+}
+
+func (s BlockSizeSplit) validate() error {
+	if len(s) == 0 {
+		return fmt.Errorf("empty block size split")
+	}
+	var total int
+	for _, share := range s {
+		if share.Size == 0 || share.Percent <= 0 {
+			return fmt.Errorf("invalid block size split %s: want positive sizes and percentages", s)
+		}
+		total += share.Percent
+	}
+	if total != 100 {
+		return fmt.Errorf("invalid block size split %s: percentages add up to %d, want 100", s, total)
+	}
+	return nil
+}
+
+// max returns the largest block size in the split.
+func (s BlockSizeSplit) max() uint64 {
+	var max uint64
+	for _, share := range s {
+		if share.Size > max {
+			max = share.Size
+		}
+	}
+	return max
+}
+
+// apportion returns the part of the measured IOPS and bandwidth of I/O
+// issued with the split that's of the given block size. fio doesn't report
+// stats by block size, so this goes by the configured weights: IOPS by the
+// share of I/Os, bandwidth by the share of bytes. Either way, the parts add
+// up to the whole.
+func (s BlockSizeSplit) apportion(size uint64, iops, bandwidth float64) (float64, float64) {
+	var percent, bytes float64
+	for _, share := range s {
+		if share.Size == size {
+			percent += float64(share.Percent)
+		}
+		bytes += float64(share.Size) * float64(share.Percent)
+	}
+	if bytes == 0 {
+		return 0, 0
+	}
+	return iops * percent / 100, bandwidth * float64(size) * percent / bytes
+}
+
+// sizes returns the distinct block sizes across the given splits, in
+// increasing order.
+func sizes(splits ...BlockSizeSplit) []uint64 {
+	seen := make(map[uint64]bool)
+	var sizes []uint64
+	for _, s := range splits {
+		for _, share := range s {
+			if !seen[share.Size] {
+				seen[share.Size] = true
+				sizes = append(sizes, share.Size)
+			}
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes
+}
+
+// DataPattern describes the contents of the buffers written out, which
+// matters for devices that compress or deduplicate data.
+type DataPattern struct {
+	// CompressPercentage is how compressible buffers are, as a percentage
+	// (i.e. fio's buffer_compress_percentage).
+	CompressPercentage int `json:"compress_percentage,omitempty"`
+	// DedupePercentage is the percentage of buffers that are duplicates of
+	// earlier ones (i.e. fio's dedupe_percentage).
+	DedupePercentage int `json:"dedupe_percentage,omitempty"`
+	// RefillBuffers has buffers refilled with random data on every write,
+	// rather than filled once and reused (i.e. fio's refill_buffers). It's
+	// implied by either percentage above.
+	RefillBuffers bool `json:"refill_buffers,omitempty"`
+	// ScrambleBuffers has buffers slightly modified on every write, which
+	// defeats deduplication more cheaply than refilling them (i.e. fio's
+	// scramble_buffers). fio scrambles buffers by default, but probes only do
+	// if this is set to true; results record it either way.
+	ScrambleBuffers *bool `json:"scramble_buffers,omitempty"`
+}
+
+// incompressible is what probes write unless configured otherwise: fresh
+// random data for every write, so compressing or deduplicating storage can't
+// inflate write numbers.
+var incompressible = DataPattern{RefillBuffers: true}
+
+// isDefault returns whether the pattern is what write probes use by default.
+// Results from before there was one record no pattern at all (not even
+// whether buffers were scrambled), which is treated the same, so their
+// descriptions still match.
+func (d DataPattern) isDefault() bool {
+	if d == (DataPattern{}) {
+		return true
+	}
+	if d.ScrambleBuffers == nil || *d.ScrambleBuffers {
+		return false
+	}
+	d.ScrambleBuffers = nil
+	return d == incompressible
+}
+
+func (d DataPattern) validate() error {
+	if d.CompressPercentage < 0 || d.CompressPercentage > 100 {
+		return fmt.Errorf("invalid compress percentage: %d", d.CompressPercentage)
+	}
+	if d.DedupePercentage < 0 || d.DedupePercentage > 100 {
+		return fmt.Errorf("invalid dedupe percentage: %d", d.DedupePercentage)
+	}
+	return nil
+}
+
+func (d DataPattern) String() string {
+	var s string
+	if d.CompressPercentage != 0 {
+		s += fmt.Sprintf("/compress=%d", d.CompressPercentage)
+	}
+	if d.DedupePercentage != 0 {
+		s += fmt.Sprintf("/dedupe=%d", d.DedupePercentage)
+	}
+	if d.RefillBuffers {
+		s += "/refill"
+	}
+	if d.ScrambleBuffers != nil {
+		s += fmt.Sprintf("/scramble=%d", boolToInt(*d.ScrambleBuffers))
+	}
+	return s
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}